@@ -5,8 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"home-guard/internal/agent"
@@ -14,45 +20,72 @@ import (
 	"home-guard/internal/mqtt"
 	"home-guard/internal/notify"
 	"home-guard/internal/process"
+	"home-guard/internal/session"
+	"home-guard/internal/update"
+)
+
+const (
+	updateTriggerFile  = "update.trigger"
+	installTriggerFile = "update.install"
+	clientIDFile       = "client_id.txt"
+
+	updateStatusPollInterval = 5 * time.Second
+	outboxStatsInterval      = time.Minute
+	healthInterval           = 30 * time.Second
 )
 
 type App struct {
 	cfg        *config.Config
 	configPath string
+	version    string
 	mqtt       *mqtt.Client
 	agent      *agent.Agent
 	notifier   notify.Notifier
+	recovered  atomic.Bool
+
+	stateMu    sync.Mutex
+	agentState update.AgentState
+
+	outboxMu    sync.Mutex
+	outboxStats mqtt.PublishStats
+
+	lastHealth time.Time
 }
 
 func NewApp(cfg *config.Config, configPath string, notifier notify.Notifier, version string) *App {
 	manager := process.NewManager(process.NewWindowsAdapter())
 	mqttClient := mqtt.NewClient(cfg)
+	mqttClient.SetVersion(version)
+	if err := mqttClient.SetOutboxPath(filepath.Join(filepath.Dir(configPath), "outbox.json")); err != nil {
+		log.Printf("failed to load MQTT outbox: %v", err)
+	}
 
 	a := &App{
 		cfg:        cfg,
 		configPath: configPath,
+		version:    version,
 		mqtt:       mqttClient,
 		notifier:   notifier,
 	}
 
 	mqttClient.SetOnConnect(func() {
 		log.Printf("mqtt: connected to broker")
-		if err := mqttClient.PublishDiscovery(); err != nil {
-			log.Printf("failed to publish HA discovery: %v", err)
-		}
-		if err := mqttClient.PublishStatus("online"); err != nil {
-			log.Printf("failed to publish status on reconnect: %v", err)
-		}
-		if err := mqttClient.PublishVersion(version); err != nil {
-			log.Printf("failed to publish version: %v", err)
-		}
+		a.resync()
+	})
+	mqttClient.SetOnPublishError(func(string, error) {
+		a.publishOutbox(false)
+	})
+	mqttClient.SetOnBirth(func() {
+		log.Printf("mqtt: republishing discovery and state for Home Assistant")
+		a.resync()
 	})
 
 	onPublish := func(mode agent.Mode) {
-		statTopic := fmt.Sprintf("stat/%s/current_mode", cfg.ClientID)
+		statTopic := mqttClient.Topics().Stat("current_mode")
 		if err := mqttClient.Publish(statTopic, string(mode)); err != nil {
 			log.Printf("failed to publish mode: %v", err)
 		}
+		a.saveAgentState(func(s *update.AgentState) { s.Mode = string(mode) })
 	}
 
 	a.agent = agent.New(manager, cfg, configPath, onPublish)
@@ -62,6 +95,25 @@ func NewApp(cfg *config.Config, configPath string, notifier notify.Notifier, ver
 			log.Printf("failed to publish running apps: %v", err)
 		}
 	})
+	a.agent.SetOnPublishBlacklisted(func(names []string) {
+		if err := mqttClient.PublishRunningBlacklisted(names); err != nil {
+			log.Printf("failed to publish running blacklisted apps: %v", err)
+		}
+		a.publishQuota()
+		active, _ := session.UserActive()
+		a.saveAgentState(func(s *update.AgentState) {
+			s.RunningBlacklisted = len(names) > 0
+			s.UserActive = active
+		})
+		a.reportHealth()
+	})
+	a.agent.SetOnQuotaWarning(func(remaining time.Duration) {
+		minutes := int(remaining.Round(time.Minute) / time.Minute)
+		n := notify.Notification{Title: "Home Guard", Message: fmt.Sprintf("Plus que %d minute(s) d'utilisation aujourd'hui.", minutes)}
+		if err := a.notifier.Send(n); err != nil {
+			log.Printf("notify: quota warning failed: %v", err)
+		}
+	})
 
 	return a
 }
@@ -70,13 +122,195 @@ func (a *App) Start(ctx context.Context) error {
 	if err := a.mqtt.Connect(); err != nil {
 		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
 	}
+	a.purgePreviousClientID()
 
 	a.recoverMode(ctx)
+	a.recovered.Store(true)
+	a.publishState()
+	a.saveAgentState(func(*update.AgentState) {})
+
 	a.subscribeTopics(ctx)
 	a.agent.Start(ctx)
+	go a.watchUpdateStatus(ctx)
+	go a.watchOutbox(ctx)
 	return nil
 }
 
+func (a *App) purgePreviousClientID() {
+	path := filepath.Join(filepath.Dir(a.configPath), clientIDFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if prev := strings.TrimSpace(string(data)); prev != "" && prev != a.cfg.ClientID {
+			log.Printf("mqtt: client_id changed from %s to %s", prev, a.cfg.ClientID)
+			go func() {
+				if err := a.mqtt.Purge(prev); err != nil {
+					log.Printf("mqtt: %v", err)
+					return
+				}
+				a.saveClientID()
+			}()
+			return
+		}
+	}
+	a.saveClientID()
+}
+
+func (a *App) saveClientID() {
+	path := filepath.Join(filepath.Dir(a.configPath), clientIDFile)
+	if err := os.WriteFile(path, []byte(a.cfg.ClientID), 0644); err != nil {
+		log.Printf("failed to write %s: %v", clientIDFile, err)
+	}
+}
+
+func (a *App) resync() {
+	if err := a.mqtt.PublishDiscovery(); err != nil {
+		log.Printf("failed to publish HA discovery: %v", err)
+	}
+	if err := a.mqtt.PublishStatus("online"); err != nil {
+		log.Printf("failed to publish status on reconnect: %v", err)
+	}
+	if err := a.mqtt.PublishVersion(a.version); err != nil {
+		log.Printf("failed to publish version: %v", err)
+	}
+	a.publishOutbox(true)
+	if a.recovered.Load() {
+		a.publishState()
+	}
+}
+
+func (a *App) publishState() {
+	if err := a.mqtt.Publish(a.mqtt.Topics().Stat("current_mode"), string(a.agent.Mode())); err != nil {
+		log.Printf("failed to publish mode: %v", err)
+	}
+	a.publishBlacklist()
+	if err := a.mqtt.PublishRunningApps(a.agent.RunningApps()); err != nil {
+		log.Printf("failed to publish running apps: %v", err)
+	}
+	if err := a.mqtt.PublishRunningBlacklisted(a.agent.RunningBlacklisted()); err != nil {
+		log.Printf("failed to publish running blacklisted apps: %v", err)
+	}
+	a.publishQuota()
+	a.publishUpdate()
+}
+
+func (a *App) publishUpdate() {
+	status, err := update.LoadStatus(filepath.Dir(a.configPath))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to read %s: %v", update.StatusFile, err)
+	}
+	status.InstalledVersion = a.version
+	if status.LatestVersion == "" {
+		status.LatestVersion = a.version
+	}
+	if err := a.mqtt.PublishUpdate(status); err != nil {
+		log.Printf("failed to publish update state: %v", err)
+	}
+}
+
+func (a *App) saveAgentState(fn func(s *update.AgentState)) {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+
+	next := a.agentState
+	if next.Mode == "" {
+		next.Mode = string(a.agent.Mode())
+	}
+	fn(&next)
+	if next == a.agentState {
+		return
+	}
+	if err := update.SaveAgentState(filepath.Dir(a.configPath), next); err != nil {
+		log.Printf("failed to write %s: %v", update.AgentStateFile, err)
+		return
+	}
+	a.agentState = next
+}
+
+func (a *App) watchUpdateStatus(ctx context.Context) {
+	path := filepath.Join(filepath.Dir(a.configPath), update.StatusFile)
+	var last time.Time
+	if info, err := os.Stat(path); err == nil {
+		last = info.ModTime()
+	}
+
+	ticker := time.NewTicker(updateStatusPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(last) {
+				continue
+			}
+			last = info.ModTime()
+			a.publishUpdate()
+		}
+	}
+}
+
+func (a *App) publishOutbox(force bool) {
+	stats := a.mqtt.Stats()
+	key := stats
+	key.Sent = 0
+
+	a.outboxMu.Lock()
+	changed := key != a.outboxStats
+	a.outboxStats = key
+	a.outboxMu.Unlock()
+
+	if !changed && !force {
+		return
+	}
+	if err := a.mqtt.PublishOutbox(stats); err != nil {
+		log.Printf("failed to publish outbox stats: %v", err)
+	}
+}
+
+func (a *App) watchOutbox(ctx context.Context) {
+	ticker := time.NewTicker(outboxStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.publishOutbox(false)
+		}
+	}
+}
+
+func (a *App) reportHealth() {
+	now := time.Now()
+	if now.Sub(a.lastHealth) < healthInterval {
+		return
+	}
+	h := update.Health{Version: a.version, At: now}
+	if err := update.SaveHealth(filepath.Dir(a.configPath), h); err != nil {
+		log.Printf("failed to write %s: %v", update.HealthFile, err)
+		return
+	}
+	a.lastHealth = now
+}
+
+func (a *App) publishQuota() {
+	if err := a.mqtt.PublishQuota(a.agent.Quota()); err != nil {
+		log.Printf("failed to publish quota: %v", err)
+	}
+}
+
+func (a *App) publishBlacklist() {
+	data, err := json.Marshal(a.agent.Blacklist())
+	if err != nil {
+		log.Printf("failed to encode blacklist: %v", err)
+		return
+	}
+	if err := a.mqtt.Publish(a.mqtt.Topics().Stat("blacklist"), string(data)); err != nil {
+		log.Printf("failed to publish blacklist: %v", err)
+	}
+}
+
 func (a *App) Stop() {
 	_ = a.mqtt.PublishStatus("offline")
 	a.mqtt.Disconnect()
@@ -86,7 +320,7 @@ func (a *App) recoverMode(ctx context.Context) {
 	recoverCh := make(chan agent.Mode, 1)
 	var once sync.Once
 
-	statModeTopic := fmt.Sprintf("stat/%s/current_mode", a.cfg.ClientID)
+	statModeTopic := a.mqtt.Topics().Stat("current_mode")
 	if err := a.mqtt.Subscribe(statModeTopic, func(payload []byte) {
 		once.Do(func() {
 			if mode := agent.Mode(strings.TrimSpace(string(payload))); mode != "" {
@@ -103,58 +337,229 @@ func (a *App) recoverMode(ctx context.Context) {
 		a.agent.SetMode(ctx, mode)
 	case <-time.After(2 * time.Second):
 	}
+
+	if err := a.mqtt.Unsubscribe(statModeTopic); err != nil {
+		log.Printf("failed to unsubscribe from %s: %v", statModeTopic, err)
+	}
 }
 
 func (a *App) subscribeTopics(ctx context.Context) {
-	notifyTopic := fmt.Sprintf("cmnd/%s/notify", a.cfg.ClientID)
-	if err := a.mqtt.Subscribe(notifyTopic, func(payload []byte) {
-		go a.handleNotify(payload)
-	}); err != nil {
-		log.Printf("failed to subscribe to %s: %v", notifyTopic, err)
+	commands := []struct {
+		name    string
+		handler mqtt.CommandHandler
+	}{
+		{"mode", func(cmd mqtt.Command) mqtt.Result { return a.handleMode(ctx, cmd) }},
+		{"blacklist/set", a.handleBlacklist},
+		{"discovery_prefix", a.handleDiscoveryPrefix},
+		{"blacklist/add", a.handleBlacklistAdd},
+		{"blacklist/remove", a.handleBlacklistRemove},
+		{"quota/daily_minutes", a.handleDailyMinutes},
+		{"quota/warning_minutes", a.handleWarningMinutes},
+		{"rescan", a.handleRescan},
+		{"discovery", a.handleRepublish},
+		{"update/check", a.handleUpdateCheck},
+		{"update/install", a.handleUpdateInstall},
 	}
 
-	modeTopic := fmt.Sprintf("cmnd/%s/mode", a.cfg.ClientID)
-	if err := a.mqtt.Subscribe(modeTopic, func(payload []byte) {
-		mode := agent.Mode(strings.TrimSpace(string(payload)))
-		log.Printf("cmnd: mode -> %s", mode)
-		a.agent.SetMode(ctx, mode)
-	}); err != nil {
-		log.Printf("failed to subscribe to %s: %v", modeTopic, err)
+	for _, c := range commands {
+		if err := a.mqtt.SubscribeCommand(c.name, c.handler); err != nil {
+			log.Printf("failed to subscribe to %s: %v", a.mqtt.Topics().Cmnd(c.name), err)
+		}
+	}
+	if err := a.mqtt.SubscribeCommandAsync("notify", a.handleNotify); err != nil {
+		log.Printf("failed to subscribe to %s: %v", a.mqtt.Topics().Cmnd("notify"), err)
 	}
+}
 
-	blacklistTopic := fmt.Sprintf("cmnd/%s/blacklist/set", a.cfg.ClientID)
-	if err := a.mqtt.Subscribe(blacklistTopic, func(payload []byte) {
-		log.Printf("cmnd: blacklist/set -> %s", payload)
-		a.handleBlacklist(payload)
-	}); err != nil {
-		log.Printf("failed to subscribe to %s: %v", blacklistTopic, err)
+func (a *App) handleMode(ctx context.Context, cmd mqtt.Command) mqtt.Result {
+	mode := agent.Mode(strings.TrimSpace(string(cmd.Payload)))
+	log.Printf("cmnd: mode -> %s", mode)
+	if mode != agent.ModeActive && mode != agent.ModeBlocked {
+		log.Printf("invalid mode: %q", mode)
+		return mqtt.Nack(fmt.Errorf("invalid mode %q", mode), nil)
 	}
+	a.agent.SetMode(ctx, mode)
+	return mqtt.Ack("mode updated", mode)
 }
 
-func (a *App) handleNotify(payload []byte) {
+func (a *App) handleNotify(cmd mqtt.Command) (result mqtt.Result) {
 	defer func() {
 		if p := recover(); p != nil {
 			log.Printf("notify: panic: %v", p)
+			result = mqtt.Nack(fmt.Errorf("panic: %v", p), nil)
 		}
 	}()
 
+	if ttl := time.Duration(a.cfg.MQTT.NotifyExpiry) * time.Second; ttl > 0 && !cmd.Sent.IsZero() && time.Since(cmd.Sent) > ttl {
+		log.Printf("cmnd: notify expired (sent %s)", cmd.Sent.Format(time.RFC3339))
+		return mqtt.Nack(fmt.Errorf("notification expired"), nil)
+	}
+
 	var n notify.Notification
-	if err := json.Unmarshal(payload, &n); err != nil {
-		n = notify.Notification{Title: "Home Guard", Message: strings.TrimSpace(string(payload))}
+	if err := json.Unmarshal(cmd.Payload, &n); err != nil {
+		n = notify.Notification{Title: "Home Guard", Message: strings.TrimSpace(string(cmd.Payload))}
 	}
 	log.Printf("cmnd: notify -> %q %q", n.Title, n.Message)
 	if err := a.notifier.Send(n); err != nil {
 		log.Printf("notify: send failed: %v", err)
+		return mqtt.Nack(err, n)
 	}
+	return mqtt.Ack("notification sent", n)
 }
 
-func (a *App) handleBlacklist(payload []byte) {
+func (a *App) handleBlacklist(cmd mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: blacklist/set -> %s", cmd.Payload)
+
 	var apps []string
-	if err := json.Unmarshal(payload, &apps); err != nil {
+	if err := json.Unmarshal(cmd.Payload, &apps); err != nil {
 		log.Printf("invalid blacklist payload: %v", err)
-		return
+		return mqtt.Nack(fmt.Errorf("invalid blacklist payload: %w", err), a.agent.Blacklist())
+	}
+	err := a.agent.SetBlacklist(apps)
+	a.publishBlacklist()
+	if err != nil {
+		log.Printf("failed to save blacklist: %v", err)
+		return mqtt.Nack(fmt.Errorf("failed to save blacklist: %w", err), a.agent.Blacklist())
+	}
+	return mqtt.Ack("blacklist updated", a.agent.Blacklist())
+}
+
+func (a *App) handleDiscoveryPrefix(cmd mqtt.Command) mqtt.Result {
+	prefix := strings.Trim(strings.TrimSpace(string(cmd.Payload)), "/")
+	log.Printf("cmnd: discovery_prefix -> %s", prefix)
+	if prefix == "" {
+		return mqtt.Nack(fmt.Errorf("empty discovery prefix"), a.mqtt.Topics().DiscoveryPrefix())
+	}
+
+	if err := a.agent.SetDiscoveryPrefix(prefix); err != nil {
+		log.Printf("failed to save discovery prefix: %v", err)
+		return mqtt.Nack(fmt.Errorf("failed to save discovery prefix: %w", err), a.mqtt.Topics().DiscoveryPrefix())
+	}
+	// Moving the subscriptions blocks on the broker, which the MQTT
+	// callback must not do.
+	go a.mqtt.SetDiscoveryPrefix(prefix)
+	return mqtt.Ack("discovery prefix updated", prefix)
+}
+
+func splitNames(payload []byte) []string {
+	var names []string
+	for _, name := range strings.Split(string(payload), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (a *App) handleBlacklistAdd(cmd mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: blacklist/add -> %s", cmd.Payload)
+
+	apps := a.agent.Blacklist()
+	for _, name := range splitNames(cmd.Payload) {
+		if !slices.ContainsFunc(apps, func(app string) bool { return strings.EqualFold(app, name) }) {
+			apps = append(apps, name)
+		}
 	}
-	if err := a.agent.SetBlacklist(apps); err != nil {
+	return a.updateBlacklist(apps)
+}
+
+func (a *App) handleBlacklistRemove(cmd mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: blacklist/remove -> %s", cmd.Payload)
+
+	names := splitNames(cmd.Payload)
+	apps := slices.DeleteFunc(a.agent.Blacklist(), func(app string) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(app, name) })
+	})
+	return a.updateBlacklist(apps)
+}
+
+func (a *App) updateBlacklist(apps []string) mqtt.Result {
+	err := a.agent.SetBlacklist(apps)
+	a.publishBlacklist()
+	if err != nil {
 		log.Printf("failed to save blacklist: %v", err)
+		return mqtt.Nack(fmt.Errorf("failed to save blacklist: %w", err), a.agent.Blacklist())
+	}
+	return mqtt.Ack("blacklist updated", a.agent.Blacklist())
+}
+
+func parseMinutes(payload []byte, maxMinutes int) (int, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minutes %q", payload)
+	}
+	minutes := int(math.Round(value))
+	if minutes < 0 || minutes > maxMinutes {
+		return 0, fmt.Errorf("minutes must be between 0 and %d", maxMinutes)
+	}
+	return minutes, nil
+}
+
+func (a *App) handleDailyMinutes(cmd mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: quota/daily_minutes -> %s", cmd.Payload)
+	minutes, err := parseMinutes(cmd.Payload, 24*60)
+	if err != nil {
+		return mqtt.Nack(err, a.agent.Quota())
+	}
+	return a.updateQuota(minutes, a.agent.Quota().WarningMinutes)
+}
+
+func (a *App) handleWarningMinutes(cmd mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: quota/warning_minutes -> %s", cmd.Payload)
+	minutes, err := parseMinutes(cmd.Payload, 60)
+	if err != nil {
+		return mqtt.Nack(err, a.agent.Quota())
 	}
+	return a.updateQuota(a.agent.Quota().DailyMinutes, minutes)
+}
+
+func (a *App) updateQuota(daily, warning int) mqtt.Result {
+	err := a.agent.SetQuota(daily, warning)
+	a.publishQuota()
+	if err != nil {
+		log.Printf("failed to save quota: %v", err)
+		return mqtt.Nack(fmt.Errorf("failed to save quota: %w", err), a.agent.Quota())
+	}
+	return mqtt.Ack("quota updated", a.agent.Quota())
+}
+
+func (a *App) handleRescan(mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: rescan")
+	a.agent.Rescan()
+	return mqtt.Ack("rescan requested", nil)
+}
+
+func (a *App) handleRepublish(mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: discovery")
+	a.resync()
+	return mqtt.Ack("discovery republished", nil)
+}
+
+func (a *App) handleUpdateCheck(mqtt.Command) mqtt.Result {
+	log.Printf("cmnd: update/check")
+	if err := a.requestUpdate(updateTriggerFile); err != nil {
+		return mqtt.Nack(err, nil)
+	}
+	return mqtt.Ack("update check requested", nil)
+}
+
+func (a *App) handleUpdateInstall(cmd mqtt.Command) mqtt.Result {
+	payload := strings.TrimSpace(string(cmd.Payload))
+	log.Printf("cmnd: update/install -> %s", payload)
+	if payload != "install" {
+		return mqtt.Nack(fmt.Errorf("unexpected payload %q", payload), nil)
+	}
+	if err := a.requestUpdate(installTriggerFile); err != nil {
+		return mqtt.Nack(err, nil)
+	}
+	return mqtt.Ack("update install requested", nil)
+}
+
+func (a *App) requestUpdate(trigger string) error {
+	path := filepath.Join(filepath.Dir(a.configPath), trigger)
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		log.Printf("failed to request update: %v", err)
+		return fmt.Errorf("failed to request update: %w", err)
+	}
+	return nil
 }
@@ -6,9 +6,11 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"syscall"
 
 	"home-guard/internal/config"
+	"home-guard/internal/mqtt"
 	"home-guard/internal/notify"
 )
 
@@ -22,6 +24,12 @@ func main() {
 			return
 		case "uninstall":
 			uninstallService()
+			if slices.Contains(os.Args[2:], "--purge") {
+				runPurge()
+			}
+			return
+		case "purge":
+			runPurge()
 			return
 		case "notify":
 			runNotify()
@@ -45,6 +53,28 @@ func runNotify() {
 	}
 }
 
+func runPurge() {
+	execPath, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := config.Load(filepath.Join(filepath.Dir(execPath), "config.json"))
+	if err != nil {
+		log.Fatalf("purge: failed to load config: %v", err)
+	}
+
+	client := mqtt.NewPassiveClient(cfg, "purge")
+	if err := client.Connect(); err != nil {
+		log.Fatalf("purge: failed to connect to MQTT broker: %v", err)
+	}
+	err = client.Purge(cfg.ClientID)
+	client.Disconnect()
+	if err != nil {
+		log.Fatalf("purge: %v", err)
+	}
+}
+
 func writeVersionFile(dir, v string) {
 	if err := os.WriteFile(filepath.Join(dir, "version.txt"), []byte(v), 0644); err != nil {
 		log.Printf("failed to write version.txt: %v", err)
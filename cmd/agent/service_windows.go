@@ -7,10 +7,14 @@ import (
 	"os"
 	"path/filepath"
 
+	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc/mgr"
 )
 
-const serviceName = "HomeGuard"
+const (
+	serviceName = "HomeGuard"
+	configSDDL  = "D:P(A;;FA;;;SY)(A;;FA;;;BA)"
+)
 
 func installService() {
 	execPath, err := os.Executable()
@@ -36,9 +40,26 @@ func installService() {
 	}
 	defer s.Close()
 
+	if err := protectConfig(filepath.Join(filepath.Dir(execPath), "config.json")); err != nil {
+		log.Fatalf("install: failed to restrict access to config.json: %v", err)
+	}
+
 	log.Printf("service %q installed successfully", serviceName)
 }
 
+func protectConfig(path string) error {
+	sd, err := windows.SecurityDescriptorFromString(configSDDL)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	return windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+}
+
 func uninstallService() {
 	m, err := mgr.Connect()
 	if err != nil {
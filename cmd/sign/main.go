@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"home-guard/internal/config"
+	"home-guard/internal/mqtt"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	configPath := flag.String("config", "", "path to the agent config.json (provides secret, client_id and topic layout)")
+	secret := flag.String("secret", "", "shared command secret (overrides auth.secret)")
+	clientID := flag.String("client-id", "", "agent client_id (overrides client_id)")
+	id := flag.String("id", "", "optional command id echoed in the result")
+	replyTo := flag.String("reply-to", "", "optional topic for the command result")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: home-guard-sign [flags] <command> [payload]\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "example: home-guard-sign -config config.json mode BLOCKED\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := &config.Config{}
+	if *configPath != "" {
+		loaded, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("sign: failed to load config: %v", err)
+		}
+		cfg = loaded
+	}
+	if *secret != "" {
+		cfg.Auth.Secret = *secret
+	}
+	if *clientID != "" {
+		cfg.ClientID = *clientID
+	}
+	if cfg.Auth.Secret == "" || cfg.ClientID == "" {
+		log.Fatal("sign: a secret and a client_id are required")
+	}
+
+	var payload []byte
+	if flag.NArg() > 1 {
+		payload = []byte(flag.Arg(1))
+	}
+
+	topic := mqtt.NewTopics(cfg.Topics, cfg.ClientID).Cmnd(flag.Arg(0))
+	data, err := mqtt.SignCommand(cfg.Auth.Secret, topic, mqtt.Envelope{ID: *id, ReplyTo: *replyTo}, payload, time.Now())
+	if err != nil {
+		log.Fatalf("sign: %v", err)
+	}
+
+	fmt.Println(string(data))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"home-guard/internal/update"
+)
+
+const unknownVersion = "local"
+
+var releaseFiles = []string{"home-guard.exe", "checksums.txt", "checksums.txt" + signatureSuffix, "version.txt"}
+
+func runApply(execDir, path string) error {
+	dir, cleanup, err := unpackRelease(path)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	cfg, err := loadUpdateConfig(execDir)
+	if err != nil {
+		return err
+	}
+	key, err := releaseKey(cfg)
+	if err != nil {
+		return err
+	}
+	checksums, err := os.ReadFile(filepath.Join(dir, "checksums.txt"))
+	if err != nil {
+		return err
+	}
+	signature, err := os.ReadFile(filepath.Join(dir, "checksums.txt"+signatureSuffix))
+	if err != nil {
+		return fmt.Errorf("missing signature: %w", err)
+	}
+	if err := verifySignature(key, checksums, signature); err != nil {
+		return fmt.Errorf("checksums.txt: %w", err)
+	}
+	expectedHash, err := findChecksum(string(checksums), "home-guard.exe")
+	if err != nil {
+		return fmt.Errorf("parse checksums: %w", err)
+	}
+	binPath := filepath.Join(dir, "home-guard.exe")
+	if err := verifyChecksum(binPath, expectedHash); err != nil {
+		return fmt.Errorf("checksum mismatch: %w", err)
+	}
+	version, err := releaseVersion(dir, path, string(checksums))
+	if err != nil {
+		return err
+	}
+
+	newBinPath := filepath.Join(execDir, "home-guard.exe.new")
+	if err := copyFile(binPath, newBinPath); err != nil {
+		return fmt.Errorf("stage binary: %w", err)
+	}
+
+	running, err := stopService()
+	if err != nil {
+		os.Remove(newBinPath)
+		return fmt.Errorf("stop service: %w", err)
+	}
+
+	w := newWrapper(execDir)
+	previous, _ := readVersionFile(execDir)
+	err = w.install(version, previous, newBinPath)
+	if err != nil {
+		w.setStatus(func(s *update.Status) { s.Error = err.Error() })
+	}
+
+	if running {
+		if serr := startService(); serr != nil {
+			log.Printf("apply: failed to restart service: %v", serr)
+			if err == nil {
+				err = fmt.Errorf("restart service: %w", serr)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("apply: installed %s from %s", version, path)
+	return nil
+}
+
+func unpackRelease(path string) (string, func(), error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return path, func() {}, nil
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("open archive: %w", err)
+	}
+	defer r.Close()
+
+	dir, err := os.MkdirTemp("", "home-guard-apply-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	for _, f := range r.File {
+		name := filepath.Base(filepath.FromSlash(f.Name))
+		if f.FileInfo().IsDir() || !slices.Contains(releaseFiles, name) {
+			continue
+		}
+		if err := extractFile(f, filepath.Join(dir, name)); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("extract %s: %w", f.Name, err)
+		}
+	}
+	return dir, cleanup, nil
+}
+
+func extractFile(f *zip.File, dest string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return writeFile(src, dest)
+}
+
+func copyFile(src, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(f, dest)
+}
+
+func writeFile(r io.Reader, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func releaseVersion(dir, path, checksums string) (string, error) {
+	versionPath := filepath.Join(dir, "version.txt")
+	if _, err := os.Stat(versionPath); err == nil {
+		expectedHash, err := findChecksum(checksums, "version.txt")
+		if err != nil {
+			return "", fmt.Errorf("parse checksums: %w", err)
+		}
+		if err := verifyChecksum(versionPath, expectedHash); err != nil {
+			return "", fmt.Errorf("checksum mismatch: %w", err)
+		}
+		v, err := readVersionFile(dir)
+		if err != nil {
+			return "", err
+		}
+		if _, err := parseVersion(v); err != nil {
+			return "", fmt.Errorf("version.txt: %w", err)
+		}
+		return v, nil
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), ".zip")
+	if _, err := parseVersion(name); err == nil {
+		return name, nil
+	}
+	name = strings.TrimPrefix(name, "home-guard-")
+	if _, err := parseVersion(name); err == nil {
+		return name, nil
+	}
+	return unknownVersion, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRelease(t *testing.T, dir string, binary []byte, priv ed25519.PrivateKey) map[string][]byte {
+	t.Helper()
+	checksums := fmt.Appendf(nil, "%x  home-guard.exe\n", sha256.Sum256(binary))
+	files := map[string][]byte{
+		"home-guard.exe":    binary,
+		"checksums.txt":     checksums,
+		"checksums.txt.sig": []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, checksums))),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func setupApply(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := releasePublicKey
+	releasePublicKey = base64.StdEncoding.EncodeToString(pub)
+	t.Cleanup(func() { releasePublicKey = old })
+
+	execDir := t.TempDir()
+	os.WriteFile(filepath.Join(execDir, "home-guard.exe"), []byte("old"), 0644)
+	os.WriteFile(filepath.Join(execDir, "version.txt"), []byte("v1.0.0"), 0644)
+	return execDir, priv
+}
+
+func TestApplyFromZip(t *testing.T) {
+	execDir, priv := setupApply(t)
+
+	files := writeRelease(t, t.TempDir(), []byte("new"), priv)
+	archive := filepath.Join(t.TempDir(), "v1.1.0.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range files {
+		w, _ := zw.Create("home-guard-v1.1.0/" + name)
+		w.Write(data)
+	}
+	zw.Close()
+	f.Close()
+
+	if err := runApply(execDir, archive); err != nil {
+		t.Fatalf("runApply() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(execDir, "home-guard.exe")); string(data) != "new" {
+		t.Errorf("home-guard.exe = %q, want new binary", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(execDir, "home-guard.exe"+prevSuffix)); string(data) != "old" {
+		t.Errorf("home-guard.exe.prev = %q, want old binary", data)
+	}
+	p := loadProbation(execDir)
+	if p == nil || p.Version != "v1.1.0" || p.Previous != "v1.0.0" {
+		t.Errorf("probation = %+v, want v1.0.0 -> v1.1.0", p)
+	}
+}
+
+func TestApplyRejectsTamperedRelease(t *testing.T) {
+	execDir, priv := setupApply(t)
+
+	dir := filepath.Join(t.TempDir(), "v1.1.0")
+	writeRelease(t, dir, []byte("new"), priv)
+	os.WriteFile(filepath.Join(dir, "home-guard.exe"), []byte("evil"), 0644)
+
+	if err := runApply(execDir, dir); err == nil {
+		t.Fatal("expected tampered binary to be rejected")
+	}
+	if data, _ := os.ReadFile(filepath.Join(execDir, "home-guard.exe")); string(data) != "old" {
+		t.Errorf("home-guard.exe = %q, want untouched", data)
+	}
+
+	os.Remove(filepath.Join(dir, "checksums.txt.sig"))
+	if err := runApply(execDir, dir); err == nil {
+		t.Error("expected release without signature to be rejected")
+	}
+}
+
+func TestReleaseVersion(t *testing.T) {
+	cases := map[string]string{
+		"E:/v1.2.0.zip":            "v1.2.0",
+		"E:/home-guard-v1.2.0.zip": "v1.2.0",
+		"E:/v1.3.0-rc.1":           "v1.3.0-rc.1",
+		"E:/release.zip":           "local",
+	}
+	for path, want := range cases {
+		if got, err := releaseVersion(t.TempDir(), path, ""); err != nil || got != want {
+			t.Errorf("releaseVersion(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestApplyReadsBundledVersion(t *testing.T) {
+	execDir, priv := setupApply(t)
+
+	dir := filepath.Join(t.TempDir(), "release")
+	files := writeRelease(t, dir, []byte("new"), priv)
+	version := []byte("v1.1.0\n")
+	checksums := fmt.Appendf(files["checksums.txt"], "%x  version.txt\n", sha256.Sum256(version))
+	os.WriteFile(filepath.Join(dir, "version.txt"), version, 0644)
+	os.WriteFile(filepath.Join(dir, "checksums.txt"), checksums, 0644)
+	os.WriteFile(filepath.Join(dir, "checksums.txt.sig"), []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, checksums))), 0644)
+
+	if err := runApply(execDir, dir); err != nil {
+		t.Fatalf("runApply() error = %v", err)
+	}
+	if p := loadProbation(execDir); p == nil || p.Version != "v1.1.0" {
+		t.Errorf("probation = %+v, want version from version.txt", p)
+	}
+}
+
+func TestApplyRejectsUnsignedVersion(t *testing.T) {
+	execDir, priv := setupApply(t)
+
+	dir := filepath.Join(t.TempDir(), "release")
+	writeRelease(t, dir, []byte("new"), priv)
+	os.WriteFile(filepath.Join(dir, "version.txt"), []byte("v9.9.9"), 0644)
+
+	if err := runApply(execDir, dir); err == nil {
+		t.Fatal("expected version.txt missing from checksums.txt to be rejected")
+	}
+	if data, _ := os.ReadFile(filepath.Join(execDir, "home-guard.exe")); string(data) != "old" {
+		t.Errorf("home-guard.exe = %q, want untouched", data)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"home-guard/internal/config"
+)
+
+const (
+	apiTimeout      = 30 * time.Second
+	downloadTimeout = 10 * time.Minute
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type rateLimitError struct {
+	until time.Time
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited until %s", e.until.Format(time.RFC3339))
+}
+
+type cachedResponse struct {
+	etag string
+	body []byte
+}
+
+type httpClient struct {
+	mu          sync.Mutex
+	client      *http.Client
+	proxy       string
+	token       string
+	tokenHost   string
+	cache       map[string]cachedResponse
+	rateLimited time.Time
+	now         func() time.Time
+}
+
+func newHTTPClient() *httpClient {
+	c := &httpClient{cache: make(map[string]cachedResponse), now: time.Now}
+	c.client = &http.Client{Transport: newTransport(nil)}
+	return c
+}
+
+func newTransport(proxy *url.URL) *http.Transport {
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 15 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	return t
+}
+
+func (c *httpClient) configure(cfg config.UpdateConfig, apiURL string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cfg.Proxy != c.proxy {
+		var proxy *url.URL
+		if cfg.Proxy != "" {
+			u, err := url.Parse(cfg.Proxy)
+			if err != nil {
+				return fmt.Errorf("invalid proxy: %w", err)
+			}
+			proxy = u
+		}
+		c.client = &http.Client{Transport: newTransport(proxy)}
+		c.proxy = cfg.Proxy
+	}
+
+	c.token = cfg.Token
+	c.tokenHost = ""
+	if u, err := url.Parse(apiURL); err == nil {
+		c.tokenHost = u.Host
+	}
+	return nil
+}
+
+func (c *httpClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	client := c.client
+	token, tokenHost := c.token, c.tokenHost
+	limited := c.rateLimited
+	c.mu.Unlock()
+
+	if c.now().Before(limited) {
+		return nil, &rateLimitError{until: limited}
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "home-guard-updater/"+version)
+	if token != "" && req.URL.Host == tokenHost {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if until, ok := c.rateLimitReset(resp); ok {
+		resp.Body.Close()
+		c.mu.Lock()
+		c.rateLimited = until
+		c.mu.Unlock()
+		return nil, &rateLimitError{until: until}
+	}
+	return resp, nil
+}
+
+func (c *httpClient) rateLimitReset(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return c.now().Add(time.Duration(secs) * time.Second), true
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return time.Time{}, false
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return c.now().Add(time.Hour), true
+	}
+	return time.Unix(reset, 0), true
+}
+
+func (c *httpClient) getJSON(rawURL string, v any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	c.mu.Lock()
+	cached, hasCache := c.cache[rawURL]
+	c.mu.Unlock()
+	if hasCache {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	switch {
+	case resp.StatusCode == http.StatusNotModified && hasCache:
+		body = cached.body
+	case resp.StatusCode == http.StatusOK:
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return err
+		}
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			c.mu.Lock()
+			c.cache[rawURL] = cachedResponse{etag: etag, body: body}
+			c.mu.Unlock()
+		}
+	default:
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(bytes.NewReader(body)).Decode(v)
+}
+
+func (c *httpClient) open(rawURL string, offset int64) (io.ReadCloser, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		cancel()
+		return nil, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, false, err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		cancel()
+		return nil, false, fmt.Errorf("GET %s: %w", rawURL, errRangeNotSatisfiable)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		cancel()
+		return nil, false, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return &cancelBody{resp.Body, cancel}, resp.StatusCode == http.StatusPartialContent, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
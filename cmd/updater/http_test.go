@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"home-guard/internal/config"
+)
+
+func TestHTTPClientRejectsErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	hc := newHTTPClient()
+
+	var releases []release
+	if err := hc.getJSON(srv.URL+"/releases", &releases); err == nil {
+		t.Error("expected error for a 404 API response")
+	}
+
+	dest := filepath.Join(t.TempDir(), "home-guard.exe.new")
+	src := &githubSource{url: srv.URL, http: hc}
+	if err := downloadFile(src, asset{BrowserDownloadURL: srv.URL + "/home-guard.exe"}, dest, "v1.0.0", nil); err == nil {
+		t.Error("expected error for a 404 download")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("expected no file to be written for a 404 download")
+	}
+}
+
+func TestHTTPClientUsesETag(t *testing.T) {
+	var full atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"tag_name":"v1.2.0"}]`))
+	}))
+	defer srv.Close()
+	hc := newHTTPClient()
+
+	for range 2 {
+		var releases []release
+		if err := hc.getJSON(srv.URL, &releases); err != nil {
+			t.Fatalf("getJSON() error = %v", err)
+		}
+		if len(releases) != 1 || releases[0].TagName != "v1.2.0" {
+			t.Fatalf("releases = %+v, want v1.2.0", releases)
+		}
+	}
+	if n := full.Load(); n != 1 {
+		t.Errorf("server sent the full body %d times, want 1", n)
+	}
+}
+
+func TestHTTPClientHonoursRateLimit(t *testing.T) {
+	var hits atomic.Int32
+	reset := time.Now().Add(time.Hour).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	hc := newHTTPClient()
+
+	var releases []release
+	err := hc.getJSON(srv.URL, &releases)
+	var rl *rateLimitError
+	if !errors.As(err, &rl) || rl.until.Unix() != reset {
+		t.Fatalf("getJSON() error = %v, want rate limit until %d", err, reset)
+	}
+	if err := hc.getJSON(srv.URL, &releases); !errors.As(err, &rl) {
+		t.Fatalf("getJSON() error = %v, want rate limit", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("server hit %d times, want 1 while rate limited", n)
+	}
+
+	hc.now = func() time.Time { return time.Unix(reset+1, 0) }
+	hc.getJSON(srv.URL, &releases)
+	if n := hits.Load(); n != 2 {
+		t.Errorf("server hit %d times, want a new request after the reset", n)
+	}
+}
+
+func TestHTTPClientSendsTokenToAPIHostOnly(t *testing.T) {
+	var apiAuth, otherAuth atomic.Value
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiAuth.Store(r.Header.Get("Authorization"))
+		w.Write([]byte(`[]`))
+	}))
+	defer api.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherAuth.Store(r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+
+	hc := newHTTPClient()
+	src, err := newReleaseSource(config.UpdateConfig{URL: api.URL, Token: "secret"}, hc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.releases(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetchBytes(src, asset{BrowserDownloadURL: other.URL + "/home-guard.exe"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := apiAuth.Load(); got != "Bearer secret" {
+		t.Errorf("API Authorization = %v, want bearer token", got)
+	}
+	if got := otherAuth.Load(); got != "" {
+		t.Errorf("download Authorization = %v, want none", got)
+	}
+}
+
+func TestHTTPClientUsesProxy(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.String())
+		w.Write([]byte(`[]`))
+	}))
+	defer proxy.Close()
+
+	hc := newHTTPClient()
+	if err := hc.configure(config.UpdateConfig{Proxy: proxy.URL}, ""); err != nil {
+		t.Fatal(err)
+	}
+	var releases []release
+	if err := hc.getJSON("http://releases.invalid/home-guard.json", &releases); err != nil {
+		t.Fatalf("getJSON() error = %v", err)
+	}
+	if got := proxied.Load(); got != "http://releases.invalid/home-guard.json" {
+		t.Errorf("proxy saw %v, want the upstream URL", got)
+	}
+}
+
+func TestDownloadFileResumes(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var ranges atomic.Value
+	ranges.Store("")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges.Store(r.Header.Get("Range"))
+		http.ServeContent(w, r, "home-guard.exe", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "home-guard.exe.new")
+	if err := os.WriteFile(dest+".v1.0.0.part", content[:400], 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := &githubSource{http: newHTTPClient()}
+	a := asset{BrowserDownloadURL: srv.URL + "/home-guard.exe", Size: int64(len(content))}
+
+	var progress []int
+	if err := downloadFile(src, a, dest, "v1.0.0", func(p int) { progress = append(progress, p) }); err != nil {
+		t.Fatalf("downloadFile() error = %v", err)
+	}
+	if len(progress) == 0 || progress[0] <= 40 || progress[len(progress)-1] != 100 {
+		t.Errorf("progress = %v, want to continue from 40%% up to 100%%", progress)
+	}
+	if got := ranges.Load(); got != "bytes=400-" {
+		t.Errorf("Range = %q, want %q", got, "bytes=400-")
+	}
+	if data, _ := os.ReadFile(dest); !bytes.Equal(data, content) {
+		t.Errorf("downloaded %d bytes, want the full %d", len(data), len(content))
+	}
+
+	a.Size++
+	os.Remove(dest)
+	if err := downloadFile(src, a, dest, "v1.0.0", nil); err == nil {
+		t.Error("expected size mismatch error")
+	}
+}
+
+func TestDownloadFileDiscardsOtherVersionPartial(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	var ranges atomic.Value
+	ranges.Store("")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges.Store(r.Header.Get("Range"))
+		http.ServeContent(w, r, "home-guard.exe", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "home-guard.exe.new")
+	stale := dest + ".v1.0.0.part"
+	if err := os.WriteFile(stale, []byte("old release"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := &githubSource{http: newHTTPClient()}
+	a := asset{BrowserDownloadURL: srv.URL + "/home-guard.exe", Size: int64(len(content))}
+
+	if err := downloadFile(src, a, dest, "v1.1.0", nil); err != nil {
+		t.Fatalf("downloadFile() error = %v", err)
+	}
+	if got := ranges.Load(); got != "" {
+		t.Errorf("Range = %q, want a full download", got)
+	}
+	if data, _ := os.ReadFile(dest); !bytes.Equal(data, content) {
+		t.Errorf("downloaded %d bytes, want the full %d", len(data), len(content))
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("expected the partial of the other version to be removed")
+	}
+}
+
+func TestDownloadFileRestartsWhenRangeNotSatisfiable(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "home-guard.exe", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "home-guard.exe.new")
+	if err := os.WriteFile(dest+".v1.0.0.part", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := &githubSource{http: newHTTPClient()}
+	a := asset{BrowserDownloadURL: srv.URL + "/home-guard.exe"}
+
+	if err := downloadFile(src, a, dest, "v1.0.0", nil); err != nil {
+		t.Fatalf("downloadFile() error = %v", err)
+	}
+	if data, _ := os.ReadFile(dest); !bytes.Equal(data, content) {
+		t.Errorf("downloaded %d bytes, want the full %d", len(data), len(content))
+	}
+}
@@ -16,9 +16,35 @@ func main() {
 
 	execDir := filepath.Dir(execPath)
 
+	if len(os.Args) > 2 && os.Args[1] == helperCommand {
+		runHelper(execDir, os.Args[2])
+		return
+	}
+	removeHelper(execDir)
+
+	if len(os.Args) > 1 && os.Args[1] == "apply" {
+		if len(os.Args) < 3 {
+			log.Fatal("usage: home-guard-updater apply <directory|archive.zip>")
+		}
+		if err := runApply(execDir, os.Args[2]); err != nil {
+			log.Fatalf("apply: %v", err)
+		}
+		return
+	}
+
 	if isWindowsService() {
 		runAsService(execDir)
 	} else {
 		runWrapper(execDir)
 	}
 }
+
+func runHelper(execDir, newVersion string) {
+	if logFile, err := os.OpenFile(filepath.Join(execDir, "updater.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		defer logFile.Close()
+		log.SetOutput(logFile)
+	}
+	if err := runSelfUpdateHelper(execDir, newVersion); err != nil {
+		log.Printf("helper: %v", err)
+	}
+}
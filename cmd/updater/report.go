@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"home-guard/internal/config"
+	"home-guard/internal/mqtt"
+	"home-guard/internal/update"
+)
+
+const reporterRetryInterval = time.Minute
+
+type updaterState struct {
+	update.Status
+	UpdaterVersion string `json:"updater_version"`
+}
+
+type reporter struct {
+	client *mqtt.Client
+
+	mu        sync.Mutex
+	connected bool
+	last      *update.Status
+}
+
+func newReporter(execDir string) *reporter {
+	cfg, err := config.Load(filepath.Join(execDir, "config.json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("updater: MQTT reporting disabled: %v", err)
+		}
+		return nil
+	}
+	return &reporter{client: mqtt.NewPassiveClient(cfg, "updater")}
+}
+
+func (r *reporter) start(ctx context.Context) {
+	for {
+		err := r.client.Connect()
+		if err == nil {
+			break
+		}
+		log.Printf("updater: MQTT connect failed: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reporterRetryInterval):
+		}
+	}
+
+	r.mu.Lock()
+	r.connected = true
+	last := r.last
+	r.mu.Unlock()
+	if last != nil {
+		r.send(*last)
+	}
+}
+
+func (r *reporter) publish(s update.Status) {
+	r.mu.Lock()
+	r.last = &s
+	connected := r.connected
+	r.mu.Unlock()
+	if connected {
+		r.send(s)
+	}
+}
+
+func (r *reporter) send(s update.Status) {
+	if err := r.client.PublishUpdater(updaterState{Status: s, UpdaterVersion: version}); err != nil {
+		log.Printf("updater: failed to publish status: %v", err)
+	}
+}
+
+func (r *reporter) stop() {
+	r.client.Disconnect()
+}
+
+func (w *wrapper) startReporting(ctx context.Context) func() {
+	r := newReporter(w.execDir)
+	if r == nil {
+		return func() {}
+	}
+
+	w.mu.Lock()
+	w.report = r.publish
+	current := w.status
+	w.mu.Unlock()
+	r.last = &current
+
+	go r.start(ctx)
+	return r.stop
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"home-guard/internal/update"
+)
+
+const (
+	prevSuffix        = ".prev"
+	badVersionsFile   = "bad_versions.json"
+	badUpdatersFile   = "bad_updater_versions.json"
+	probationFile     = "probation.json"
+	probationWindow   = 5 * time.Minute
+	maxProbationExits = 3
+	healthTimeout     = 2 * time.Minute
+)
+
+type probation struct {
+	Version  string    `json:"version"`
+	Previous string    `json:"previous"`
+	Since    time.Time `json:"since"`
+	Exits    int       `json:"exits"`
+}
+
+func loadProbation(dir string) *probation {
+	data, err := os.ReadFile(filepath.Join(dir, probationFile))
+	if err != nil {
+		return nil
+	}
+	var p probation
+	if err := json.Unmarshal(data, &p); err != nil {
+		log.Printf("updater: invalid %s: %v", probationFile, err)
+		return nil
+	}
+	return &p
+}
+
+func (w *wrapper) saveProbation() {
+	path := filepath.Join(w.execDir, probationFile)
+	if w.probation == nil {
+		os.Remove(path)
+		return
+	}
+	data, err := json.Marshal(w.probation)
+	if err == nil {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		log.Printf("updater: failed to write %s: %v", probationFile, err)
+	}
+}
+
+func (w *wrapper) startProbation(version, previous string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.probation = &probation{Version: version, Previous: previous, Since: time.Now()}
+	w.saveProbation()
+}
+
+func (w *wrapper) inProbation() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.probation != nil && time.Since(w.probation.Since) >= probationWindow && w.healthy(w.probation) {
+		log.Printf("updater: %s passed probation", w.probation.Version)
+		w.probation = nil
+		w.saveProbation()
+	}
+	return w.probation != nil
+}
+
+func (w *wrapper) healthy(p *probation) bool {
+	h, err := update.LoadHealth(w.execDir)
+	if err != nil || (p.Version != unknownVersion && h.Version != p.Version) {
+		return false
+	}
+	return h.At.After(p.Since) && time.Since(h.At) <= healthTimeout
+}
+
+func (w *wrapper) checkProbation() error {
+	if !w.inProbation() {
+		return nil
+	}
+
+	w.mu.Lock()
+	version := w.probation.Version
+	expired := time.Since(w.probation.Since) >= probationWindow
+	w.mu.Unlock()
+	if !expired {
+		return nil
+	}
+
+	log.Printf("updater: %s did not report healthy during probation", version)
+	w.stopAgent()
+	return w.rollback("it never reported healthy", false)
+}
+
+func (w *wrapper) agentExited(started time.Time) bool {
+	if !w.inProbation() {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if started.Before(w.probation.Since) {
+		return false
+	}
+	w.probation.Exits++
+	w.saveProbation()
+	log.Printf("updater: %s exited during probation (%d/%d)", w.probation.Version, w.probation.Exits, maxProbationExits)
+	return w.probation.Exits >= maxProbationExits
+}
+
+func (w *wrapper) rollback(reason string, bad bool) error {
+	w.mu.Lock()
+	p := w.probation
+	w.probation = nil
+	w.saveProbation()
+	w.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	agentPath := filepath.Join(w.execDir, "home-guard.exe")
+	if err := os.Rename(agentPath+prevSuffix, agentPath); err != nil {
+		return fmt.Errorf("restore previous binary: %w", err)
+	}
+	if bad && p.Version != unknownVersion {
+		if err := w.markBad(badVersionsFile, p.Version); err != nil {
+			log.Printf("updater: failed to record bad version %s: %v", p.Version, err)
+		}
+	}
+
+	log.Printf("updater: %s rolled back to %s: %s", p.Version, p.Previous, reason)
+	w.setStatus(func(s *update.Status) {
+		s.InstalledVersion = p.Previous
+		s.Error = fmt.Sprintf("%s rolled back because %s", p.Version, reason)
+	})
+	return nil
+}
+
+func (w *wrapper) badVersions(file string) []string {
+	data, err := os.ReadFile(filepath.Join(w.execDir, file))
+	if err != nil {
+		return nil
+	}
+	var versions []string
+	if err := json.Unmarshal(data, &versions); err != nil {
+		log.Printf("updater: invalid %s: %v", file, err)
+	}
+	return versions
+}
+
+func (w *wrapper) markBad(file, version string) error {
+	versions := w.badVersions(file)
+	if slices.Contains(versions, version) {
+		return nil
+	}
+	data, err := json.Marshal(append(versions, version))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.execDir, file), data, 0644)
+}
+
+func withoutBadVersions(releases []release, bad []string) []release {
+	return slices.DeleteFunc(releases, func(r release) bool {
+		return slices.Contains(bad, r.TagName)
+	})
+}
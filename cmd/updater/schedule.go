@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"home-guard/internal/config"
+	"home-guard/internal/update"
+)
+
+const defaultMaxDeferral = 72 * time.Hour
+
+func deferReason(cfg config.UpdateConfig, state update.AgentState, now time.Time) (string, error) {
+	if cfg.Window != "" {
+		window, err := config.ParseWindow(cfg.Window)
+		if err != nil {
+			return "", err
+		}
+		if !window.Contains(now) {
+			return "outside maintenance window " + cfg.Window, nil
+		}
+	}
+	if cfg.DeferBlocked && state.Mode == "BLOCKED" {
+		return "agent is in BLOCKED mode", nil
+	}
+	if cfg.DeferRunningBlacklisted && state.RunningBlacklisted {
+		return "a blacklisted app is running", nil
+	}
+	if cfg.DeferUserActive && state.UserActive {
+		return "a user is active", nil
+	}
+	return "", nil
+}
+
+func (w *wrapper) deferral(cfg config.UpdateConfig) (string, error) {
+	state, _ := update.LoadAgentState(w.execDir)
+	now := w.now()
+	reason, err := deferReason(cfg, state, now)
+	if err != nil || reason == "" {
+		return "", err
+	}
+
+	w.mu.Lock()
+	since := w.status.DeferredSince
+	w.mu.Unlock()
+	if since.IsZero() {
+		since = now
+	}
+
+	maxDeferral := defaultMaxDeferral
+	if cfg.MaxDeferral > 0 {
+		maxDeferral = time.Duration(cfg.MaxDeferral) * time.Hour
+	}
+	if now.Sub(since) >= maxDeferral {
+		log.Printf("updater: deferred since %s, installing despite: %s", since.Format(time.RFC3339), reason)
+		return "", nil
+	}
+
+	w.setStatus(func(s *update.Status) {
+		s.Deferred = reason
+		s.DeferredSince = since
+	})
+	return reason, nil
+}
+
+func (w *wrapper) deferred() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status.Deferred != ""
+}
+
+func (w *wrapper) clearDeferral() {
+	w.setStatus(func(s *update.Status) {
+		s.Deferred = ""
+		s.DeferredSince = time.Time{}
+	})
+}
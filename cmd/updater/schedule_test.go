@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"home-guard/internal/config"
+	"home-guard/internal/update"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2026, 3, 10, hour, minute, 0, 0, time.Local)
+}
+
+func TestDeferReason(t *testing.T) {
+	cases := []struct {
+		name  string
+		cfg   config.UpdateConfig
+		state update.AgentState
+		want  bool
+	}{
+		{"no constraints", config.UpdateConfig{}, update.AgentState{Mode: "BLOCKED", RunningBlacklisted: true}, false},
+		{"outside window", config.UpdateConfig{Window: "02:00-05:00"}, update.AgentState{}, true},
+		{"blocked", config.UpdateConfig{DeferBlocked: true}, update.AgentState{Mode: "BLOCKED"}, true},
+		{"active mode", config.UpdateConfig{DeferBlocked: true}, update.AgentState{Mode: "ACTIVE"}, false},
+		{"blacklisted app running", config.UpdateConfig{DeferRunningBlacklisted: true}, update.AgentState{RunningBlacklisted: true}, true},
+		{"user active", config.UpdateConfig{DeferUserActive: true}, update.AgentState{UserActive: true}, true},
+		{"user away", config.UpdateConfig{DeferUserActive: true}, update.AgentState{RunningBlacklisted: true}, false},
+	}
+	for _, tc := range cases {
+		reason, err := deferReason(tc.cfg, tc.state, at(12, 0))
+		if err != nil {
+			t.Fatalf("%s: deferReason() error = %v", tc.name, err)
+		}
+		if (reason != "") != tc.want {
+			t.Errorf("%s: deferReason() = %q, want deferred=%v", tc.name, reason, tc.want)
+		}
+	}
+}
+
+func TestDeferralDeadline(t *testing.T) {
+	dir := t.TempDir()
+	if err := update.SaveAgentState(dir, update.AgentState{Mode: "BLOCKED"}); err != nil {
+		t.Fatal(err)
+	}
+	w := newWrapper(dir)
+	now := at(12, 0)
+	w.now = func() time.Time { return now }
+	cfg := config.UpdateConfig{DeferBlocked: true, MaxDeferral: 24}
+
+	reason, err := w.deferral(cfg)
+	if err != nil || reason == "" {
+		t.Fatalf("deferral() = %q, %v, want a reason", reason, err)
+	}
+	status, _ := update.LoadStatus(dir)
+	if status.Deferred != reason || !status.DeferredSince.Equal(now) {
+		t.Errorf("status = %+v, want deferral reported", status)
+	}
+
+	now = now.Add(23 * time.Hour)
+	if reason, _ := w.deferral(cfg); reason == "" {
+		t.Error("expected the install to stay deferred before the deadline")
+	}
+	now = now.Add(time.Hour)
+	if reason, _ := w.deferral(cfg); reason != "" {
+		t.Errorf("deferral() = %q after the deadline, want none", reason)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"home-guard/internal/config"
+)
+
+const (
+	updaterExe       = "home-guard-updater.exe"
+	helperExe        = "home-guard-updater-helper.exe"
+	helperCommand    = "self-update-helper"
+	serviceCheckStep = time.Second
+)
+
+var selfUpdateProbation = 30 * time.Second
+
+var startHelper = func(path string, args ...string) error {
+	cmd := exec.Command(path, args...)
+	cmd.Dir = filepath.Dir(path)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func wantsSelfUpdate(r *release, cfg config.UpdateConfig) bool {
+	if version == "dev" {
+		return false
+	}
+	if _, ok := findAsset(r, updaterExe); !ok {
+		return false
+	}
+	return needsUpdate(r.TagName, version, cfg)
+}
+
+func findAsset(r *release, name string) (asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return asset{}, false
+}
+
+func (w *wrapper) selfUpdate(src releaseSource, r *release, checksums string) error {
+	a, _ := findAsset(r, updaterExe)
+	expectedHash, err := findChecksum(checksums, updaterExe)
+	if err != nil {
+		return fmt.Errorf("parse checksums: %w", err)
+	}
+
+	newPath := filepath.Join(w.execDir, updaterExe+".new")
+	if err := downloadFile(src, a, newPath, r.TagName, w.reportProgress); err != nil {
+		return fmt.Errorf("download updater: %w", err)
+	}
+	if err := verifyChecksum(newPath, expectedHash); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("updater checksum mismatch: %w", err)
+	}
+
+	current := filepath.Join(w.execDir, updaterExe)
+	prev := current + prevSuffix
+	helper := filepath.Join(w.execDir, helperExe)
+	if err := copyFile(current, helper); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("copy helper: %w", err)
+	}
+
+	os.Remove(prev)
+	if err := os.Rename(current, prev); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("keep previous updater: %w", err)
+	}
+	if err := os.Rename(newPath, current); err != nil {
+		os.Remove(newPath)
+		os.Rename(prev, current)
+		return fmt.Errorf("replace updater: %w", err)
+	}
+
+	log.Printf("updater: staged updater %s, restarting service", r.TagName)
+	if err := startHelper(helper, helperCommand, r.TagName); err != nil {
+		os.Rename(prev, current)
+		return fmt.Errorf("start helper: %w", err)
+	}
+	w.setResult(resultUpdated)
+	return nil
+}
+
+func runSelfUpdateHelper(execDir, newVersion string) error {
+	if _, err := stopService(); err != nil {
+		return fmt.Errorf("stop service: %w", err)
+	}
+	if err := startService(); err == nil && serviceStaysUp(selfUpdateProbation) {
+		log.Printf("helper: updater %s is running", newVersion)
+		return nil
+	}
+
+	log.Printf("helper: updater %s did not stay up, rolling back", newVersion)
+	if _, err := stopService(); err != nil {
+		log.Printf("helper: stop service: %v", err)
+	}
+	current := filepath.Join(execDir, updaterExe)
+	if err := os.Rename(current+prevSuffix, current); err != nil {
+		return fmt.Errorf("restore previous updater: %w", err)
+	}
+	if err := newWrapper(execDir).markBad(badUpdatersFile, newVersion); err != nil {
+		log.Printf("helper: failed to record bad version %s: %v", newVersion, err)
+	}
+	return startService()
+}
+
+func serviceStaysUp(d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for {
+		running, err := serviceRunning()
+		if err != nil || !running {
+			return false
+		}
+		if time.Now().After(deadline) {
+			return true
+		}
+		time.Sleep(min(serviceCheckStep, d))
+	}
+}
+
+func removeHelper(execDir string) {
+	os.Remove(filepath.Join(execDir, helperExe))
+}
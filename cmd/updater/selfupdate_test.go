@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"home-guard/internal/config"
+)
+
+func TestSelfUpdateStagesAndStartsHelper(t *testing.T) {
+	execDir := t.TempDir()
+	os.WriteFile(filepath.Join(execDir, updaterExe), []byte("old"), 0644)
+
+	releaseDir := filepath.Join(t.TempDir(), "v1.1.0")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(filepath.Join(releaseDir, updaterExe), []byte("new"), 0644)
+	checksums := fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte("new")), updaterExe)
+
+	src := &directorySource{dir: filepath.Dir(releaseDir)}
+	releases, err := src.releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var started []string
+	defer func(fn func(string, ...string) error) { startHelper = fn }(startHelper)
+	startHelper = func(path string, args ...string) error {
+		started = append([]string{path}, args...)
+		return nil
+	}
+
+	w := newWrapper(execDir)
+	if err := w.selfUpdate(src, &releases[0], checksums); err != nil {
+		t.Fatalf("selfUpdate() error = %v", err)
+	}
+
+	for name, want := range map[string]string{updaterExe: "new", updaterExe + prevSuffix: "old", helperExe: "old"} {
+		if data, _ := os.ReadFile(filepath.Join(execDir, name)); string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+	want := []string{filepath.Join(execDir, helperExe), helperCommand, "v1.1.0"}
+	if !slices.Equal(started, want) {
+		t.Errorf("helper started with %v, want %v", started, want)
+	}
+}
+
+func TestSelfUpdateRejectsBadChecksum(t *testing.T) {
+	execDir := t.TempDir()
+	os.WriteFile(filepath.Join(execDir, updaterExe), []byte("old"), 0644)
+	releaseDir := filepath.Join(t.TempDir(), "v1.1.0")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(filepath.Join(releaseDir, updaterExe), []byte("evil"), 0644)
+
+	src := &directorySource{dir: filepath.Dir(releaseDir)}
+	releases, _ := src.releases()
+	checksums := fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte("new")), updaterExe)
+
+	if err := newWrapper(execDir).selfUpdate(src, &releases[0], checksums); err == nil {
+		t.Fatal("expected checksum mismatch")
+	}
+	if data, _ := os.ReadFile(filepath.Join(execDir, updaterExe)); string(data) != "old" {
+		t.Errorf("%s = %q, want untouched", updaterExe, data)
+	}
+}
+
+func TestSelfUpdateHelperRollsBack(t *testing.T) {
+	execDir := t.TempDir()
+	os.WriteFile(filepath.Join(execDir, updaterExe), []byte("new"), 0644)
+	os.WriteFile(filepath.Join(execDir, updaterExe+prevSuffix), []byte("old"), 0644)
+
+	defer func(d time.Duration) { selfUpdateProbation = d }(selfUpdateProbation)
+	selfUpdateProbation = 0
+
+	// Without a Windows service the new updater never reports running.
+	if err := runSelfUpdateHelper(execDir, "v1.1.0"); err != nil {
+		t.Fatalf("runSelfUpdateHelper() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(execDir, updaterExe)); string(data) != "old" {
+		t.Errorf("%s = %q, want previous updater restored", updaterExe, data)
+	}
+	w := newWrapper(execDir)
+	if !slices.Contains(w.badVersions(badUpdatersFile), "v1.1.0") {
+		t.Error("expected updater v1.1.0 to be marked bad")
+	}
+	if slices.Contains(w.badVersions(badVersionsFile), "v1.1.0") {
+		t.Error("a failed updater must not block the agent release")
+	}
+}
+
+func TestWantsSelfUpdate(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	r := &release{TagName: "v1.1.0", Assets: []asset{{Name: updaterExe}}}
+
+	version = "dev"
+	if wantsSelfUpdate(r, config.UpdateConfig{}) {
+		t.Error("dev builds should not update themselves")
+	}
+	version = "v1.0.0"
+	if !wantsSelfUpdate(r, config.UpdateConfig{}) {
+		t.Error("expected self-update to a newer release")
+	}
+	if wantsSelfUpdate(&release{TagName: "v1.1.0"}, config.UpdateConfig{}) {
+		t.Error("expected no self-update without an updater asset")
+	}
+}
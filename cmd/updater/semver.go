@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type semver struct {
+	Major, Minor, Patch int
+	Pre                 []string
+}
+
+func parseVersion(v string) (semver, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	s, _, _ = strings.Cut(s, "+")
+	core, pre, hasPre := strings.Cut(s, "-")
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return semver{}, fmt.Errorf("invalid version %q", v)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("invalid version %q", v)
+		}
+		nums[i] = n
+	}
+
+	result := semver{Major: nums[0], Minor: nums[1], Patch: nums[2]}
+	if hasPre {
+		if pre == "" {
+			return semver{}, fmt.Errorf("invalid version %q", v)
+		}
+		result.Pre = strings.Split(pre, ".")
+	}
+	return result, nil
+}
+
+func (v semver) Prerelease() bool {
+	return len(v.Pre) > 0
+}
+
+func (v semver) Compare(o semver) int {
+	if c := cmp.Compare(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := range min(len(v.Pre), len(o.Pre)) {
+		if c := comparePreIdentifier(v.Pre[i], o.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(v.Pre), len(o.Pre))
+}
+
+func comparePreIdentifier(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func isNewer(remote, local string) bool {
+	rv, err := parseVersion(remote)
+	if err != nil {
+		return false
+	}
+	lv, err := parseVersion(local)
+	if err != nil {
+		return true
+	}
+	return rv.Compare(lv) > 0
+}
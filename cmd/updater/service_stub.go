@@ -13,3 +13,9 @@ func runAsService(execDir string) {
 func runWrapper(execDir string) {
 	log.Fatal("wrapper mode is only supported on Windows")
 }
+
+func stopService() (bool, error) { return false, nil }
+
+func startService() error { return nil }
+
+func serviceRunning() (bool, error) { return false, nil }
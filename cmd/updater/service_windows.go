@@ -4,13 +4,21 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "HomeGuard"
+	serviceStopTimeout = 30 * time.Second
 )
 
 type updaterService struct {
@@ -31,6 +39,8 @@ func (s *updaterService) Execute(_ []string, r <-chan svc.ChangeRequest, status
 	defer cancel()
 
 	w := newWrapper(s.execDir)
+	stopReporting := w.startReporting(ctx)
+	defer stopReporting()
 	go w.run(ctx)
 	go w.updateLoop(ctx)
 
@@ -65,7 +75,7 @@ func runAsService(execDir string) {
 	}
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 
-	if err := svc.Run("HomeGuard", &updaterService{execDir: execDir}); err != nil {
+	if err := svc.Run(serviceName, &updaterService{execDir: execDir}); err != nil {
 		log.Fatalf("service: run failed: %v", err)
 	}
 }
@@ -78,6 +88,8 @@ func runWrapper(execDir string) {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	w := newWrapper(execDir)
+	stopReporting := w.startReporting(ctx)
+	defer stopReporting()
 	go w.updateLoop(ctx)
 	go w.run(ctx)
 
@@ -85,3 +97,75 @@ func runWrapper(execDir string) {
 	cancel()
 	w.stopAgent()
 }
+
+func stopService() (bool, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return false, nil
+	}
+	defer s.Close()
+
+	st, err := s.Query()
+	if err != nil {
+		return false, err
+	}
+	if st.State == svc.Stopped {
+		return false, nil
+	}
+
+	if _, err := s.Control(svc.Stop); err != nil {
+		return false, err
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for st.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return true, fmt.Errorf("service %s did not stop within %s", serviceName, serviceStopTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if st, err = s.Query(); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func startService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+func serviceRunning() (bool, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+
+	st, err := s.Query()
+	if err != nil {
+		return false, err
+	}
+	return st.State == svc.Running || st.State == svc.StartPending, nil
+}
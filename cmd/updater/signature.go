@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"home-guard/internal/config"
+)
+
+const signatureSuffix = ".sig"
+
+// Set at build time with -ldflags "-X main.releasePublicKey=...".
+var releasePublicKey = ""
+
+func releaseKey(cfg config.UpdateConfig) (ed25519.PublicKey, error) {
+	encoded := releasePublicKey
+	if cfg.PublicKey != "" {
+		encoded = cfg.PublicKey
+	}
+	if encoded == "" {
+		return nil, errors.New("no release public key configured")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid release public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid release public key: %d bytes, want %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func verifySignature(key ed25519.PublicKey, data, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize || !ed25519.Verify(key, data, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"home-guard/internal/config"
+)
+
+const githubAPIURL = "https://api.github.com/repos/gamachec/home-guard/releases?per_page=50"
+
+const (
+	sourceGitHub    = "github"
+	sourceManifest  = "manifest"
+	sourceDirectory = "directory"
+
+	notesFile = "notes.md"
+)
+
+type releaseSource interface {
+	releases() ([]release, error)
+	open(a asset, offset int64) (body io.ReadCloser, resumed bool, err error)
+}
+
+func newReleaseSource(cfg config.UpdateConfig, hc *httpClient) (releaseSource, error) {
+	switch cfg.Source {
+	case "", sourceGitHub:
+		apiURL := githubAPIURL
+		if cfg.URL != "" {
+			apiURL = cfg.URL
+		}
+		if err := hc.configure(cfg, apiURL); err != nil {
+			return nil, err
+		}
+		return &githubSource{url: apiURL, http: hc}, nil
+	case sourceManifest, sourceDirectory:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("update source %s requires a url", cfg.Source)
+		}
+		if cfg.Source == sourceDirectory {
+			return &directorySource{dir: cfg.URL}, nil
+		}
+		if err := hc.configure(cfg, ""); err != nil {
+			return nil, err
+		}
+		return &manifestSource{url: cfg.URL, http: hc}, nil
+	}
+	return nil, fmt.Errorf("unknown update source %q", cfg.Source)
+}
+
+type githubSource struct {
+	url  string
+	http *httpClient
+}
+
+func (s *githubSource) releases() ([]release, error) {
+	var releases []release
+	err := s.http.getJSON(s.url, &releases)
+	return releases, err
+}
+
+func (s *githubSource) open(a asset, offset int64) (io.ReadCloser, bool, error) {
+	return s.http.open(a.BrowserDownloadURL, offset)
+}
+
+type manifestSource struct {
+	url  string
+	http *httpClient
+}
+
+func (s *manifestSource) releases() ([]release, error) {
+	base, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []release
+	if err := s.http.getJSON(s.url, &releases); err != nil {
+		return nil, err
+	}
+	for i := range releases {
+		for j, a := range releases[i].Assets {
+			ref, err := url.Parse(a.BrowserDownloadURL)
+			if err != nil {
+				return nil, fmt.Errorf("release %s: asset %s: %w", releases[i].TagName, a.Name, err)
+			}
+			releases[i].Assets[j].BrowserDownloadURL = base.ResolveReference(ref).String()
+		}
+	}
+	return releases, nil
+}
+
+func (s *manifestSource) open(a asset, offset int64) (io.ReadCloser, bool, error) {
+	return s.http.open(a.BrowserDownloadURL, offset)
+}
+
+type directorySource struct {
+	dir string
+}
+
+func (s *directorySource) releases() ([]release, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []release
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		v, err := parseVersion(e.Name())
+		if err != nil {
+			continue
+		}
+
+		dir := filepath.Join(s.dir, e.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		r := release{TagName: e.Name(), Name: e.Name(), Prerelease: v.Prerelease()}
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			if f.Name() == notesFile {
+				notes, _ := os.ReadFile(filepath.Join(dir, f.Name()))
+				r.Body = string(notes)
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				return nil, err
+			}
+			r.Assets = append(r.Assets, asset{Name: f.Name(), BrowserDownloadURL: filepath.Join(dir, f.Name()), Size: info.Size()})
+		}
+		releases = append(releases, r)
+	}
+	return releases, nil
+}
+
+func (s *directorySource) open(a asset, offset int64) (io.ReadCloser, bool, error) {
+	f, err := os.Open(a.BrowserDownloadURL)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, offset > 0, nil
+}
+
+var errSizeMismatch = errors.New("size mismatch")
+
+func downloadFile(src releaseSource, a asset, dest, version string, progress func(percent int)) error {
+	part := fmt.Sprintf("%s.%s.part", dest, version)
+	removeStalePartials(dest, part)
+
+	info, err := os.Stat(part)
+	resuming := err == nil && info.Size() > 0
+
+	err = downloadPart(src, a, part, progress)
+	if resuming && (errors.Is(err, errRangeNotSatisfiable) || errors.Is(err, errSizeMismatch)) {
+		log.Printf("updater: discarding partial download %s: %v", part, err)
+		os.Remove(part)
+		err = downloadPart(src, a, part, progress)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(part, dest)
+}
+
+func removeStalePartials(dest, keep string) {
+	matches, _ := filepath.Glob(dest + ".*.part")
+	for _, m := range matches {
+		if m != keep {
+			os.Remove(m)
+		}
+	}
+}
+
+func downloadPart(src releaseSource, a asset, part string, progress func(percent int)) error {
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+	if a.Size > 0 && offset >= a.Size {
+		offset = 0
+	}
+
+	body, resumed, err := src.open(a, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resumed {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	if progress != nil && a.Size > 0 {
+		if !resumed {
+			offset = 0
+		}
+		w = &progressWriter{w: f, done: offset, total: a.Size, report: progress}
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if a.Size > 0 {
+		info, err := os.Stat(part)
+		if err != nil {
+			return err
+		}
+		if info.Size() != a.Size {
+			os.Remove(part)
+			return fmt.Errorf("%w: got %d bytes, want %d", errSizeMismatch, info.Size(), a.Size)
+		}
+	}
+	return nil
+}
+
+func fetchBytes(src releaseSource, a asset) ([]byte, error) {
+	body, _, err := src.open(a, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+type progressWriter struct {
+	w       io.Writer
+	done    int64
+	total   int64
+	percent int
+	report  func(percent int)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	if percent := int(min(p.done*100/p.total, 100)); percent != p.percent {
+		p.percent = percent
+		p.report(percent)
+	}
+	return n, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"home-guard/internal/config"
+)
+
+func TestGitHubSource(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases":
+			fmt.Fprintf(w, `[{"tag_name":"v1.2.0","prerelease":false,"assets":[{"name":"home-guard.exe","browser_download_url":"%s/dl/home-guard.exe"}]}]`, srv.URL)
+		case "/dl/home-guard.exe":
+			w.Write([]byte("binary"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src, err := newReleaseSource(config.UpdateConfig{URL: srv.URL + "/releases"}, newHTTPClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.(*githubSource); !ok {
+		t.Fatalf("newReleaseSource() = %T, want *githubSource", src)
+	}
+	releases, err := src.releases()
+	if err != nil {
+		t.Fatalf("releases() error = %v", err)
+	}
+	if len(releases) != 1 || releases[0].TagName != "v1.2.0" {
+		t.Fatalf("releases() = %+v, want v1.2.0", releases)
+	}
+
+	data, err := fetchBytes(src, releases[0].Assets[0])
+	if err != nil || string(data) != "binary" {
+		t.Errorf("fetchBytes() = %q, %v, want %q", data, err, "binary")
+	}
+}
+
+func TestManifestSourceResolvesRelativeURLs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/home-guard/releases.json":
+			w.Write([]byte(`[{"tag_name":"v1.3.0","assets":[{"name":"checksums.txt","browser_download_url":"v1.3.0/checksums.txt"}]}]`))
+		case "/home-guard/v1.3.0/checksums.txt":
+			w.Write([]byte("abc  home-guard.exe\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src, err := newReleaseSource(config.UpdateConfig{Source: "manifest", URL: srv.URL + "/home-guard/releases.json"}, newHTTPClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases, err := src.releases()
+	if err != nil {
+		t.Fatalf("releases() error = %v", err)
+	}
+	want := srv.URL + "/home-guard/v1.3.0/checksums.txt"
+	if got := releases[0].Assets[0].BrowserDownloadURL; got != want {
+		t.Errorf("asset URL = %q, want %q", got, want)
+	}
+
+	data, err := fetchBytes(src, releases[0].Assets[0])
+	if err != nil || string(data) != "abc  home-guard.exe\n" {
+		t.Errorf("fetchBytes() = %q, %v", data, err)
+	}
+}
+
+func TestDirectorySource(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"v1.2.0/home-guard.exe":      "stable",
+		"v1.2.0/checksums.txt":       "sums",
+		"v1.2.0/notes.md":            "Corrections",
+		"v1.3.0-rc.1/home-guard.exe": "beta",
+		"old/home-guard.exe":         "ignored",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := newReleaseSource(config.UpdateConfig{Source: "directory", URL: dir}, newHTTPClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases, err := src.releases()
+	if err != nil {
+		t.Fatalf("releases() error = %v", err)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("releases() = %+v, want 2 versioned directories", releases)
+	}
+
+	byTag := make(map[string]release)
+	for _, r := range releases {
+		byTag[r.TagName] = r
+	}
+	stable := byTag["v1.2.0"]
+	if stable.Body != "Corrections" || len(stable.Assets) != 2 || stable.Prerelease {
+		t.Errorf("v1.2.0 = %+v, want notes and two assets", stable)
+	}
+	if !byTag["v1.3.0-rc.1"].Prerelease {
+		t.Error("expected v1.3.0-rc.1 to be a prerelease")
+	}
+
+	selected, err := selectRelease(releases, config.UpdateConfig{})
+	if err != nil || selected.TagName != "v1.2.0" {
+		t.Fatalf("selectRelease() = %v, %v, want v1.2.0", selected, err)
+	}
+	for _, a := range selected.Assets {
+		if a.Name != "home-guard.exe" {
+			continue
+		}
+		data, err := fetchBytes(src, a)
+		if err != nil || string(data) != "stable" {
+			t.Errorf("fetchBytes() = %q, %v, want %q", data, err, "stable")
+		}
+	}
+}
+
+func TestNewReleaseSourceErrors(t *testing.T) {
+	for _, cfg := range []config.UpdateConfig{
+		{Source: "ftp"},
+		{Source: "manifest"},
+		{Source: "directory"},
+	} {
+		if _, err := newReleaseSource(cfg, newHTTPClient()); err == nil {
+			t.Errorf("newReleaseSource(%+v) expected error", cfg)
+		}
+	}
+}
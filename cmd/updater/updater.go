@@ -2,24 +2,34 @@ package main
 
 import (
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
+
+	"home-guard/internal/config"
+)
+
+const (
+	channelStable     = "stable"
+	channelPrerelease = "prerelease"
 )
 
-const githubAPIURL = "https://api.github.com/repos/gamachec/home-guard/releases/latest"
+type release struct {
+	TagName    string  `json:"tag_name"`
+	Name       string  `json:"name"`
+	Body       string  `json:"body"`
+	HTMLURL    string  `json:"html_url"`
+	Draft      bool    `json:"draft"`
+	Prerelease bool    `json:"prerelease"`
+	Assets     []asset `json:"assets"`
+}
 
-type githubRelease struct {
-	TagName string `json:"tag_name"`
-	Assets  []struct {
-		Name               string `json:"name"`
-		BrowserDownloadURL string `json:"browser_download_url"`
-	} `json:"assets"`
+type asset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Size               int64  `json:"size"`
 }
 
 func readVersionFile(dir string) (string, error) {
@@ -30,92 +40,109 @@ func readVersionFile(dir string) (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
-func latestRelease() (*githubRelease, error) {
-	req, err := http.NewRequest("GET", githubAPIURL, nil)
-	if err != nil {
-		return nil, err
+func selectRelease(releases []release, cfg config.UpdateConfig) (*release, error) {
+	channel := cfg.Channel
+	if channel == "" {
+		channel = channelStable
 	}
-	req.Header.Set("User-Agent", "home-guard-updater/"+version)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+	if channel != channelStable && channel != channelPrerelease {
+		return nil, fmt.Errorf("unknown update channel %q", cfg.Channel)
 	}
-	defer resp.Body.Close()
 
-	var release githubRelease
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return nil, err
+	bounds := make(map[string]semver)
+	for name, v := range map[string]string{"pin": cfg.Pin, "min_version": cfg.MinVersion, "max_version": cfg.MaxVersion} {
+		if v == "" {
+			continue
+		}
+		parsed, err := parseVersion(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		bounds[name] = parsed
 	}
-	return &release, nil
-}
 
-func isNewer(remote, local string) bool {
-	rv := parseVersion(remote)
-	lv := parseVersion(local)
-	for i := range rv {
-		if rv[i] > lv[i] {
-			return true
+	var best *release
+	var bestVersion semver
+	for i, r := range releases {
+		if r.Draft {
+			continue
 		}
-		if rv[i] < lv[i] {
-			return false
+		v, err := parseVersion(r.TagName)
+		if err != nil {
+			continue
+		}
+		if pin, ok := bounds["pin"]; ok {
+			if v.Compare(pin) == 0 {
+				return &releases[i], nil
+			}
+			continue
+		}
+		if channel == channelStable && (r.Prerelease || v.Prerelease()) {
+			continue
+		}
+		if lo, ok := bounds["min_version"]; ok && v.Compare(lo) < 0 {
+			continue
+		}
+		if hi, ok := bounds["max_version"]; ok && v.Compare(hi) > 0 {
+			continue
+		}
+		if best == nil || v.Compare(bestVersion) > 0 {
+			best, bestVersion = &releases[i], v
 		}
 	}
-	return false
-}
 
-func parseVersion(v string) [3]int {
-	v = strings.TrimPrefix(v, "v")
-	parts := strings.SplitN(v, ".", 3)
-	var result [3]int
-	for i, p := range parts {
-		if i >= 3 {
-			break
+	if best == nil {
+		if cfg.Pin != "" {
+			return nil, fmt.Errorf("pinned release %s not found", cfg.Pin)
 		}
-		result[i], _ = strconv.Atoi(p)
+		return nil, fmt.Errorf("no release matches channel %s", channel)
 	}
-	return result
+	return best, nil
 }
 
-func findAssets(r *githubRelease) (agentURL, checksumURL string, err error) {
-	for _, a := range r.Assets {
-		switch a.Name {
-		case "home-guard.exe":
-			agentURL = a.BrowserDownloadURL
-		case "checksums.txt":
-			checksumURL = a.BrowserDownloadURL
-		}
+func needsUpdate(remote, local string, cfg config.UpdateConfig) bool {
+	if cfg.Pin == "" {
+		return isNewer(remote, local)
 	}
-	if agentURL == "" || checksumURL == "" {
-		return "", "", fmt.Errorf("required assets not found in release %s", r.TagName)
+	rv, err := parseVersion(remote)
+	if err != nil {
+		return false
 	}
-	return
+	lv, err := parseVersion(local)
+	return err != nil || rv.Compare(lv) != 0
 }
 
-func downloadFile(url, dest string) error {
-	resp, err := http.Get(url)
+func loadUpdateConfig(dir string) (config.UpdateConfig, error) {
+	cfg, err := config.Load(filepath.Join(dir, "config.json"))
 	if err != nil {
-		return err
+		if os.IsNotExist(err) {
+			return config.UpdateConfig{}, nil
+		}
+		return config.UpdateConfig{}, fmt.Errorf("load config: %w", err)
 	}
-	defer resp.Body.Close()
-
-	f, err := os.Create(dest)
-	if err != nil {
-		return err
+	if cfg.Update.Window != "" {
+		if _, err := config.ParseWindow(cfg.Update.Window); err != nil {
+			return config.UpdateConfig{}, fmt.Errorf("update.window: %w", err)
+		}
 	}
-	defer f.Close()
-
-	_, err = io.Copy(f, resp.Body)
-	return err
+	return cfg.Update, nil
 }
 
-func fetchBytes(url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
+func findAssets(r *release) (agent, checksums, signature asset, err error) {
+	for _, a := range r.Assets {
+		switch a.Name {
+		case "home-guard.exe":
+			agent = a
+		case "checksums.txt":
+			checksums = a
+		case "checksums.txt" + signatureSuffix:
+			signature = a
+		}
 	}
-	defer resp.Body.Close()
-	return io.ReadAll(resp.Body)
+	if agent.Name == "" || checksums.Name == "" || signature.Name == "" {
+		return asset{}, asset{}, asset{}, fmt.Errorf("required assets not found in release %s", r.TagName)
+	}
+	return
 }
 
 func findChecksum(checksums, filename string) (string, error) {
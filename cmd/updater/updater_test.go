@@ -1,7 +1,16 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
+
+	"home-guard/internal/config"
+	"home-guard/internal/update"
 )
 
 func TestIsNewer(t *testing.T) {
@@ -16,6 +25,16 @@ func TestIsNewer(t *testing.T) {
 		{"v2.0.0", "v1.9.9", true},
 		{"v1.0.0", "v2.0.0", false},
 		{"v1.10.0", "v1.9.0", true},
+		{"v1.3.0", "v1.3.0-rc.1", true},
+		{"v1.3.0-rc.1", "v1.3.0", false},
+		{"v1.3.0-rc.2", "v1.3.0-rc.1", true},
+		{"v1.3.0-rc.10", "v1.3.0-rc.9", true},
+		{"v1.3.0-beta", "v1.3.0-alpha", true},
+		{"v1.3.0-alpha.1", "v1.3.0-alpha", true},
+		{"v1.3.0-alpha", "v1.3.0-1", true},
+		{"v1.3.0+build.5", "v1.3.0", false},
+		{"v1.0.0", "dev", true},
+		{"garbage", "v1.0.0", false},
 	}
 	for _, tc := range cases {
 		got := isNewer(tc.remote, tc.local)
@@ -57,17 +76,359 @@ func TestFindChecksumNotFound(t *testing.T) {
 func TestParseVersion(t *testing.T) {
 	cases := []struct {
 		input string
-		want  [3]int
+		want  semver
 	}{
-		{"v1.2.3", [3]int{1, 2, 3}},
-		{"1.2.3", [3]int{1, 2, 3}},
-		{"v2.0.0", [3]int{2, 0, 0}},
-		{"v1.10.5", [3]int{1, 10, 5}},
+		{"v1.2.3", semver{Major: 1, Minor: 2, Patch: 3}},
+		{"1.2.3", semver{Major: 1, Minor: 2, Patch: 3}},
+		{"v2.0.0", semver{Major: 2}},
+		{"v1.10.5", semver{Major: 1, Minor: 10, Patch: 5}},
+		{"v1.3.0-rc.1", semver{Major: 1, Minor: 3, Pre: []string{"rc", "1"}}},
+		{"v1.3.0-rc.1+sha.abc", semver{Major: 1, Minor: 3, Pre: []string{"rc", "1"}}},
 	}
 	for _, tc := range cases {
-		got := parseVersion(tc.input)
-		if got != tc.want {
-			t.Errorf("parseVersion(%q) = %v, want %v", tc.input, got, tc.want)
+		got, err := parseVersion(tc.input)
+		if err != nil {
+			t.Errorf("parseVersion(%q) error = %v", tc.input, err)
+			continue
+		}
+		if got.Compare(tc.want) != 0 || got.Prerelease() != tc.want.Prerelease() {
+			t.Errorf("parseVersion(%q) = %+v, want %+v", tc.input, got, tc.want)
 		}
 	}
+
+	for _, bad := range []string{"", "dev", "v1.2", "v1.2.x", "v1.2.3-"} {
+		if _, err := parseVersion(bad); err == nil {
+			t.Errorf("parseVersion(%q) expected error", bad)
+		}
+	}
+}
+
+func TestSelectRelease(t *testing.T) {
+	releases := []release{
+		{TagName: "v1.4.0", Draft: true},
+		{TagName: "v1.3.0-rc.1", Prerelease: true},
+		{TagName: "v1.2.1"},
+		{TagName: "v1.2.0"},
+		{TagName: "v1.1.0"},
+		{TagName: "nightly"},
+	}
+	cases := []struct {
+		name string
+		cfg  config.UpdateConfig
+		want string
+	}{
+		{"stable by default", config.UpdateConfig{}, "v1.2.1"},
+		{"prerelease channel", config.UpdateConfig{Channel: "prerelease"}, "v1.3.0-rc.1"},
+		{"max version", config.UpdateConfig{Channel: "prerelease", MaxVersion: "v1.2.0"}, "v1.2.0"},
+		{"pinned", config.UpdateConfig{Pin: "v1.1.0"}, "v1.1.0"},
+		{"pinned prerelease", config.UpdateConfig{Pin: "1.3.0-rc.1"}, "v1.3.0-rc.1"},
+	}
+	for _, tc := range cases {
+		got, err := selectRelease(releases, tc.cfg)
+		if err != nil {
+			t.Errorf("%s: selectRelease() error = %v", tc.name, err)
+			continue
+		}
+		if got.TagName != tc.want {
+			t.Errorf("%s: selectRelease() = %s, want %s", tc.name, got.TagName, tc.want)
+		}
+	}
+
+	errCases := []config.UpdateConfig{
+		{Channel: "nightly"},
+		{Pin: "v9.9.9"},
+		{MinVersion: "v2.0.0"},
+		{MaxVersion: "latest"},
+	}
+	for _, cfg := range errCases {
+		if _, err := selectRelease(releases, cfg); err == nil {
+			t.Errorf("selectRelease(%+v) expected error", cfg)
+		}
+	}
+}
+
+func TestNeedsUpdatePinned(t *testing.T) {
+	pinned := config.UpdateConfig{Pin: "v1.1.0"}
+	if !needsUpdate("v1.1.0", "v1.2.0", pinned) {
+		t.Error("expected downgrade to the pinned version")
+	}
+	if needsUpdate("v1.1.0", "v1.1.0", pinned) {
+		t.Error("expected no update when already on the pinned version")
+	}
+	if needsUpdate("v1.1.0", "v1.2.0", config.UpdateConfig{}) {
+		t.Error("expected no downgrade without a pin")
+	}
+}
+
+func TestLoadUpdateConfigRejectsInvalidWindow(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"broker": "localhost", "update": {"window": "2h-5h"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := config.Load(filepath.Join(dir, "config.json")); err != nil {
+		t.Fatalf("config.Load() error = %v, want the agent to keep loading its config", err)
+	}
+	if _, err := loadUpdateConfig(dir); err == nil {
+		t.Error("loadUpdateConfig() expected error for invalid update.window")
+	}
+}
+
+func TestConsumeTrigger(t *testing.T) {
+	dir := t.TempDir()
+	w := newWrapper(dir)
+
+	if w.consumeTrigger(triggerFile) {
+		t.Fatal("consumeTrigger() = true without trigger file")
+	}
+	if err := os.WriteFile(filepath.Join(dir, triggerFile), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.consumeTrigger(triggerFile) {
+		t.Fatal("consumeTrigger() = false with trigger file")
+	}
+	if w.consumeTrigger(triggerFile) {
+		t.Error("consumeTrigger() = true after trigger was consumed")
+	}
+}
+
+func TestRunCheckRecordsFailure(t *testing.T) {
+	dir := t.TempDir()
+	w := newWrapper(dir)
+	var reported []update.Status
+	w.report = func(s update.Status) { reported = append(reported, s) }
+
+	w.runCheck(false)
+
+	status, err := update.LoadStatus(dir)
+	if err != nil {
+		t.Fatalf("LoadStatus() error = %v", err)
+	}
+	if status.Error == "" || status.Result != resultFailed {
+		t.Errorf("status = %+v, want the failure to be recorded", status)
+	}
+	if len(reported) == 0 || reported[len(reported)-1].Result != resultFailed {
+		t.Errorf("reported = %+v, want the failure to be reported", reported)
+	}
+	if status.InProgress {
+		t.Error("expected in_progress to be cleared")
+	}
+	if status.CheckedAt.IsZero() {
+		t.Error("expected checked_at to be set")
+	}
+}
+
+func TestReportProgressCrossesSteps(t *testing.T) {
+	w := newWrapper(t.TempDir())
+	var reported []int
+	w.report = func(s update.Status) { reported = append(reported, s.Progress) }
+
+	for _, percent := range []int{3, 15, 17, 42, 99, 100} {
+		w.reportProgress(percent)
+	}
+
+	if want := []int{15, 42, 99, 100}; !slices.Equal(reported, want) {
+		t.Errorf("reported = %v, want %v", reported, want)
+	}
+}
+
+func TestProbationRollsBackCrashLoop(t *testing.T) {
+	dir := t.TempDir()
+	agentPath := filepath.Join(dir, "home-guard.exe")
+	if err := os.WriteFile(agentPath, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(agentPath+prevSuffix, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newWrapper(dir)
+	before := time.Now().Add(-time.Second)
+	w.startProbation("v2.0.0", "v1.0.0")
+
+	if w.agentExited(before) {
+		t.Fatal("exit of the agent stopped for the update should not count")
+	}
+	for i := 1; i < maxProbationExits; i++ {
+		if w.agentExited(time.Now()) {
+			t.Fatalf("rollback requested after %d exits", i)
+		}
+	}
+	if !w.agentExited(time.Now()) {
+		t.Fatal("expected rollback after repeated exits")
+	}
+	if err := w.rollback("it crashed", true); err != nil {
+		t.Fatalf("rollback() error = %v", err)
+	}
+
+	data, err := os.ReadFile(agentPath)
+	if err != nil || string(data) != "old" {
+		t.Errorf("agent binary = %q, %v, want previous binary restored", data, err)
+	}
+	if !slices.Contains(w.badVersions(badVersionsFile), "v2.0.0") {
+		t.Errorf("badVersions() = %v, want v2.0.0", w.badVersions(badVersionsFile))
+	}
+	releases := withoutBadVersions([]release{{TagName: "v2.0.0"}, {TagName: "v1.0.0"}}, w.badVersions(badVersionsFile))
+	if len(releases) != 1 || releases[0].TagName != "v1.0.0" {
+		t.Errorf("withoutBadVersions() = %v, want only v1.0.0", releases)
+	}
+	status, _ := update.LoadStatus(dir)
+	if status.InstalledVersion != "v1.0.0" || status.Error == "" {
+		t.Errorf("status = %+v, want rollback recorded", status)
+	}
+}
+
+func TestProbationEnds(t *testing.T) {
+	dir := t.TempDir()
+	w := newWrapper(dir)
+	w.startProbation("v2.0.0", "v1.0.0")
+	w.probation.Since = time.Now().Add(-probationWindow)
+	if err := update.SaveHealth(dir, update.Health{Version: "v2.0.0", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.agentExited(time.Now()) {
+		t.Error("exit after the probation window should not trigger a rollback")
+	}
+	if w.inProbation() {
+		t.Error("expected probation to be over")
+	}
+}
+
+func TestProbationRollsBackUnhealthyVersion(t *testing.T) {
+	dir := t.TempDir()
+	agentPath := filepath.Join(dir, "home-guard.exe")
+	if err := os.WriteFile(agentPath, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(agentPath+prevSuffix, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newWrapper(dir)
+	w.startProbation("v2.0.0", "v1.0.0")
+	if err := w.checkProbation(); err != nil || !w.inProbation() {
+		t.Fatalf("checkProbation() = %v, want probation to continue within the window", err)
+	}
+
+	w.probation.Since = time.Now().Add(-probationWindow)
+	if err := update.SaveHealth(dir, update.Health{Version: "v1.0.0", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.checkProbation(); err != nil {
+		t.Fatalf("checkProbation() error = %v", err)
+	}
+	if w.inProbation() {
+		t.Error("expected probation to end with a rollback")
+	}
+	if data, _ := os.ReadFile(agentPath); string(data) != "old" {
+		t.Errorf("agent binary = %q, want previous binary restored", data)
+	}
+	if bad := w.badVersions(badVersionsFile); len(bad) != 0 {
+		t.Errorf("badVersions() = %v, want a version that never reported healthy to be retried", bad)
+	}
+}
+
+func TestProbationWithUnknownVersion(t *testing.T) {
+	dir := t.TempDir()
+	agentPath := filepath.Join(dir, "home-guard.exe")
+	os.WriteFile(agentPath, []byte("new"), 0644)
+	os.WriteFile(agentPath+prevSuffix, []byte("old"), 0644)
+
+	w := newWrapper(dir)
+	w.startProbation(unknownVersion, "v1.0.0")
+	w.probation.Since = time.Now().Add(-probationWindow)
+	if err := update.SaveHealth(dir, update.Health{Version: "v2.0.0", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if w.inProbation() {
+		t.Error("expected a fresh heartbeat to end the probation of an unknown version")
+	}
+
+	w.startProbation(unknownVersion, "v1.0.0")
+	if err := w.rollback("test", true); err != nil {
+		t.Fatalf("rollback() error = %v", err)
+	}
+	if bad := w.badVersions(badVersionsFile); len(bad) != 0 {
+		t.Errorf("badVersions() = %v, want the placeholder version not recorded", bad)
+	}
+}
+
+func TestProbationSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	newWrapper(dir).startProbation("v2.0.0", "v1.0.0")
+
+	w := newWrapper(dir)
+	if !w.inProbation() || w.probation.Version != "v2.0.0" {
+		t.Errorf("probation = %+v, want v2.0.0 restored after a restart", w.probation)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums := []byte("abc123ef  home-guard.exe\n")
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, checksums)))
+
+	if err := verifySignature(pub, checksums, signature); err != nil {
+		t.Errorf("verifySignature() error = %v", err)
+	}
+	if err := verifySignature(pub, []byte("000000ff  home-guard.exe\n"), signature); err == nil {
+		t.Error("expected tampered checksums to be rejected")
+	}
+	if err := verifySignature(pub, checksums, nil); err == nil {
+		t.Error("expected missing signature to be rejected")
+	}
+	if err := verifySignature(pub, checksums, []byte("not base64!")); err == nil {
+		t.Error("expected malformed signature to be rejected")
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if err := verifySignature(otherPub, checksums, signature); err == nil {
+		t.Error("expected signature from another key to be rejected")
+	}
+}
+
+func TestReleaseKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(pub)
+
+	defer func(v string) { releasePublicKey = v }(releasePublicKey)
+	releasePublicKey = ""
+
+	if _, err := releaseKey(config.UpdateConfig{}); err == nil {
+		t.Error("expected error without any public key")
+	}
+
+	releasePublicKey = encoded
+	key, err := releaseKey(config.UpdateConfig{})
+	if err != nil || !key.Equal(pub) {
+		t.Errorf("releaseKey() = %v, %v, want compiled-in key", key, err)
+	}
+
+	override, _, _ := ed25519.GenerateKey(nil)
+	key, err = releaseKey(config.UpdateConfig{PublicKey: base64.StdEncoding.EncodeToString(override)})
+	if err != nil || !key.Equal(override) {
+		t.Errorf("releaseKey() = %v, %v, want key from config", key, err)
+	}
+
+	if _, err := releaseKey(config.UpdateConfig{PublicKey: "c2hvcnQ="}); err == nil {
+		t.Error("expected error for a key of the wrong size")
+	}
+}
+
+func TestFindAssetsRequiresSignature(t *testing.T) {
+	release := &release{TagName: "v1.0.0"}
+	for _, name := range []string{"home-guard.exe", "checksums.txt"} {
+		release.Assets = append(release.Assets, asset{Name: name, BrowserDownloadURL: "https://example.org/" + name})
+	}
+
+	if _, _, _, err := findAssets(release); err == nil {
+		t.Error("expected error when checksums.txt.sig is missing")
+	}
 }
@@ -7,8 +7,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
+
+	"home-guard/internal/update"
 )
 
 type wrapper struct {
@@ -16,10 +19,52 @@ type wrapper struct {
 	mu      sync.Mutex
 	cmd     *exec.Cmd
 	done    chan struct{}
+	status  update.Status
+
+	probation *probation
+	http      *httpClient
+	now       func() time.Time
+	result    string
+	report    func(update.Status)
 }
 
 func newWrapper(execDir string) *wrapper {
-	return &wrapper{execDir: execDir}
+	w := &wrapper{execDir: execDir, http: newHTTPClient(), now: time.Now}
+	w.status, _ = update.LoadStatus(execDir)
+	w.status.InProgress = false
+	w.probation = loadProbation(execDir)
+	return w
+}
+
+func (w *wrapper) setStatus(fn func(s *update.Status)) {
+	w.mu.Lock()
+	fn(&w.status)
+	s := w.status
+	report := w.report
+	w.mu.Unlock()
+
+	if err := update.SaveStatus(w.execDir, s); err != nil {
+		log.Printf("updater: failed to write %s: %v", update.StatusFile, err)
+	}
+	if report != nil {
+		report(s)
+	}
+}
+
+func (w *wrapper) setResult(result string) {
+	w.mu.Lock()
+	w.result = result
+	w.mu.Unlock()
+}
+
+func (w *wrapper) reportProgress(percent int) {
+	w.mu.Lock()
+	last := w.status.Progress
+	w.mu.Unlock()
+	if percent/progressStep == last/progressStep {
+		return
+	}
+	w.setStatus(func(s *update.Status) { s.Progress = percent })
 }
 
 func (w *wrapper) run(ctx context.Context) {
@@ -27,6 +72,7 @@ func (w *wrapper) run(ctx context.Context) {
 		agentPath := filepath.Join(w.execDir, "home-guard.exe")
 		cmd := exec.CommandContext(ctx, agentPath)
 		done := make(chan struct{})
+		started := time.Now()
 
 		w.mu.Lock()
 		w.cmd = cmd
@@ -56,6 +102,12 @@ func (w *wrapper) run(ctx context.Context) {
 		w.done = nil
 		w.mu.Unlock()
 
+		if w.agentExited(started) {
+			if err := w.rollback(fmt.Sprintf("it crashed %d times", maxProbationExits), true); err != nil {
+				log.Printf("updater: rollback failed: %v", err)
+			}
+		}
+
 		select {
 		case <-ctx.Done():
 			return
@@ -91,83 +143,213 @@ func (w *wrapper) stopAgent() {
 	}
 }
 
-func (w *wrapper) checkAndUpdate() error {
+func (w *wrapper) checkAndUpdate(force bool) error {
 	localVersion, err := readVersionFile(w.execDir)
 	if err != nil {
 		return fmt.Errorf("read version: %w", err)
 	}
 
-	release, err := latestRelease()
+	if w.inProbation() {
+		log.Printf("updater: skipping update check during probation")
+		w.setResult(resultProbation)
+		return nil
+	}
+
+	cfg, err := loadUpdateConfig(w.execDir)
 	if err != nil {
-		return fmt.Errorf("fetch release: %w", err)
+		return err
+	}
+	source, err := newReleaseSource(cfg, w.http)
+	if err != nil {
+		return err
+	}
+	releases, err := source.releases()
+	if err != nil {
+		return fmt.Errorf("fetch releases: %w", err)
+	}
+	release, err := selectRelease(withoutBadVersions(releases, w.badVersions(badVersionsFile)), cfg)
+	if err != nil {
+		return err
 	}
 
-	if !isNewer(release.TagName, localVersion) {
+	w.setStatus(func(s *update.Status) {
+		s.InstalledVersion = localVersion
+		s.LatestVersion = release.TagName
+		s.Title = release.Name
+		s.ReleaseSummary = update.Summary(release.Body)
+		s.ReleaseURL = release.HTMLURL
+	})
+
+	agentUpdate := needsUpdate(release.TagName, localVersion, cfg)
+	selfUpdate := wantsSelfUpdate(release, cfg) && !slices.Contains(w.badVersions(badUpdatersFile), release.TagName)
+	if !agentUpdate && !selfUpdate {
+		w.clearDeferral()
 		return nil
 	}
 
-	log.Printf("updater: new version available: %s -> %s", localVersion, release.TagName)
+	if !force {
+		reason, err := w.deferral(cfg)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			log.Printf("updater: deferring %s: %s", release.TagName, reason)
+			return nil
+		}
+	}
+	w.clearDeferral()
 
-	agentURL, checksumURL, err := findAssets(release)
+	agentAsset, checksumAsset, signatureAsset, err := findAssets(release)
 	if err != nil {
 		return err
 	}
 
-	newBinPath := filepath.Join(w.execDir, "home-guard.exe.new")
-	if err := downloadFile(agentURL, newBinPath); err != nil {
-		return fmt.Errorf("download: %w", err)
+	key, err := releaseKey(cfg)
+	if err != nil {
+		return err
 	}
 
-	checksumsData, err := fetchBytes(checksumURL)
+	checksumsData, err := fetchBytes(source, checksumAsset)
 	if err != nil {
-		os.Remove(newBinPath)
 		return fmt.Errorf("download checksums: %w", err)
 	}
-
-	expectedHash, err := findChecksum(string(checksumsData), "home-guard.exe")
+	signature, err := fetchBytes(source, signatureAsset)
 	if err != nil {
-		os.Remove(newBinPath)
-		return fmt.Errorf("parse checksums: %w", err)
+		return fmt.Errorf("download checksums signature: %w", err)
+	}
+	if err := verifySignature(key, checksumsData, signature); err != nil {
+		return fmt.Errorf("checksums.txt: %w", err)
 	}
 
-	if err := verifyChecksum(newBinPath, expectedHash); err != nil {
-		os.Remove(newBinPath)
-		return fmt.Errorf("checksum mismatch: %w", err)
+	if agentUpdate {
+		log.Printf("updater: new version available: %s -> %s", localVersion, release.TagName)
+		w.setStatus(func(s *update.Status) { s.InProgress = true })
+
+		expectedHash, err := findChecksum(string(checksumsData), "home-guard.exe")
+		if err != nil {
+			return fmt.Errorf("parse checksums: %w", err)
+		}
+
+		newBinPath := filepath.Join(w.execDir, "home-guard.exe.new")
+		if err := downloadFile(source, agentAsset, newBinPath, release.TagName, w.reportProgress); err != nil {
+			return fmt.Errorf("download: %w", err)
+		}
+
+		if err := verifyChecksum(newBinPath, expectedHash); err != nil {
+			os.Remove(newBinPath)
+			return fmt.Errorf("checksum mismatch: %w", err)
+		}
+
+		if err := w.install(release.TagName, localVersion, newBinPath); err != nil {
+			return err
+		}
 	}
 
+	if selfUpdate {
+		return w.selfUpdate(source, release, string(checksumsData))
+	}
+	return nil
+}
+
+func (w *wrapper) install(version, previous, newBinPath string) error {
 	agentPath := filepath.Join(w.execDir, "home-guard.exe")
+	prevPath := agentPath + prevSuffix
 
 	w.stopAgent()
 
+	os.Remove(prevPath)
+	if err := os.Rename(agentPath, prevPath); err != nil && !os.IsNotExist(err) {
+		os.Remove(newBinPath)
+		return fmt.Errorf("keep previous binary: %w", err)
+	}
 	if err := os.Rename(newBinPath, agentPath); err != nil {
+		os.Rename(prevPath, agentPath)
 		return fmt.Errorf("replace binary: %w", err)
 	}
+	w.startProbation(version, previous)
+	w.setResult(resultUpdated)
+	w.setStatus(func(s *update.Status) { s.InstalledVersion = version })
 
-	log.Printf("updater: updated to %s", release.TagName)
+	log.Printf("updater: updated to %s", version)
 	return nil
 }
 
-func (w *wrapper) updateLoop(ctx context.Context) {
-	select {
-	case <-ctx.Done():
-		return
-	case <-time.After(time.Minute):
-	}
+const (
+	triggerFile         = "update.trigger"
+	installTriggerFile  = "update.install"
+	triggerPollInterval = 2 * time.Second
+
+	deferredRecheckInterval = 10 * time.Minute
+
+	progressStep = 10
+)
+
+const (
+	resultUpToDate  = "up_to_date"
+	resultUpdated   = "updated"
+	resultDeferred  = "deferred"
+	resultProbation = "probation"
+	resultFailed    = "failed"
+)
 
-	if err := w.checkAndUpdate(); err != nil {
+func (w *wrapper) consumeTrigger(name string) bool {
+	err := os.Remove(filepath.Join(w.execDir, name))
+	return err == nil
+}
+
+func (w *wrapper) runCheck(force bool) {
+	w.setResult(resultUpToDate)
+	err := w.checkAndUpdate(force)
+	if err != nil {
 		log.Printf("updater: update check failed: %v", err)
 	}
+	w.setStatus(func(s *update.Status) {
+		s.InProgress = false
+		s.Progress = 0
+		s.CheckedAt = time.Now()
+		s.Result = w.result
+		if s.Deferred != "" && s.Result == resultUpToDate {
+			s.Result = resultDeferred
+		}
+		s.Error = ""
+		if err != nil {
+			s.Result = resultFailed
+			s.Error = err.Error()
+		}
+	})
+}
 
+func (w *wrapper) updateLoop(ctx context.Context) {
+	first := time.After(time.Minute)
 	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
+	poll := time.NewTicker(triggerPollInterval)
+	defer poll.Stop()
+	recheck := time.NewTicker(deferredRecheckInterval)
+	defer recheck.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-first:
+			w.runCheck(false)
 		case <-ticker.C:
-			if err := w.checkAndUpdate(); err != nil {
-				log.Printf("updater: update check failed: %v", err)
+			w.runCheck(false)
+		case <-recheck.C:
+			if w.deferred() {
+				w.runCheck(false)
+			}
+		case <-poll.C:
+			if err := w.checkProbation(); err != nil {
+				log.Printf("updater: rollback failed: %v", err)
+			}
+			if w.consumeTrigger(installTriggerFile) {
+				log.Printf("updater: update install requested by the agent")
+				w.runCheck(true)
+			} else if w.consumeTrigger(triggerFile) {
+				log.Printf("updater: update check requested by the agent")
+				w.runCheck(false)
 			}
 		}
 	}
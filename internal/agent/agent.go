@@ -20,11 +20,22 @@ type Agent struct {
 	mu               sync.RWMutex
 	mode             Mode
 	blacklist        []string
+	running          []process.ProcessInfo
+	blacklisted      []string
 	cfg              *config.Config
 	configPath       string
 	manager          *process.Manager
 	onPublish        func(mode Mode)
 	onPublishRunning func(apps []process.ProcessInfo)
+	onBlacklisted    func(names []string)
+	onQuotaWarning   func(remaining time.Duration)
+	rescan           chan struct{}
+	now              func() time.Time
+	usage            time.Duration
+	usageDay         string
+	usageLoaded      bool
+	lastScan         time.Time
+	warned           bool
 	stopBlock        context.CancelFunc
 	killDelay        func() time.Duration
 	scanDelay        func() time.Duration
@@ -45,6 +56,8 @@ func New(
 		onPublish:  onPublish,
 		killDelay:  defaultKillDelay,
 		scanDelay:  defaultScanDelay,
+		rescan:     make(chan struct{}, 1),
+		now:        time.Now,
 	}
 }
 
@@ -60,6 +73,21 @@ func (a *Agent) SetOnPublishRunning(fn func(apps []process.ProcessInfo)) {
 	a.onPublishRunning = fn
 }
 
+func (a *Agent) SetOnPublishBlacklisted(fn func(names []string)) {
+	a.onBlacklisted = fn
+}
+
+func (a *Agent) SetOnQuotaWarning(fn func(remaining time.Duration)) {
+	a.onQuotaWarning = fn
+}
+
+func (a *Agent) Rescan() {
+	select {
+	case a.rescan <- struct{}{}:
+	default:
+	}
+}
+
 func (a *Agent) Start(ctx context.Context) {
 	go a.runScanLoop(ctx)
 }
@@ -91,6 +119,12 @@ func (a *Agent) SetMode(ctx context.Context, mode Mode) {
 	}
 }
 
+func (a *Agent) Mode() Mode {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.mode
+}
+
 func (a *Agent) SetBlacklist(apps []string) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -110,15 +144,60 @@ func (a *Agent) Blacklist() []string {
 	return result
 }
 
+func (a *Agent) SetDiscoveryPrefix(prefix string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.cfg.Topics.DiscoveryPrefix = prefix
+
+	return config.Save(a.configPath, a.cfg)
+}
+
+func (a *Agent) RunningApps() []process.ProcessInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := make([]process.ProcessInfo, len(a.running))
+	copy(result, a.running)
+	return result
+}
+
+func (a *Agent) RunningBlacklisted() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := make([]string, len(a.blacklisted))
+	copy(result, a.blacklisted)
+	return result
+}
+
 func (a *Agent) runScanLoop(ctx context.Context) {
 	for {
-		if apps, err := a.manager.RunningApps(); err == nil && a.onPublishRunning != nil {
-			a.onPublishRunning(apps)
+		if apps, err := a.manager.RunningApps(); err == nil {
+			a.mu.Lock()
+			a.running = apps
+			a.mu.Unlock()
+
+			if a.onPublishRunning != nil {
+				a.onPublishRunning(apps)
+			}
+		}
+
+		if names, err := a.manager.RunningFromBlacklist(a.Blacklist()); err == nil {
+			a.mu.Lock()
+			a.blacklisted = names
+			a.mu.Unlock()
+
+			a.trackUsage(names)
+			if a.onBlacklisted != nil {
+				a.onBlacklisted(names)
+			}
 		}
 
 		select {
 		case <-ctx.Done():
 			return
+		case <-a.rescan:
 		case <-time.After(a.scanDelay()):
 		}
 	}
@@ -129,6 +129,45 @@ func TestSetModePublishesState(t *testing.T) {
 	}
 }
 
+func TestModeReflectsSetMode(t *testing.T) {
+	a := newTestAgent(&config.Config{}, "", &mockAdapter{}, nil)
+
+	if a.Mode() != ModeActive {
+		t.Errorf("Mode() = %q, want %q", a.Mode(), ModeActive)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.SetMode(ctx, ModeBlocked)
+
+	if a.Mode() != ModeBlocked {
+		t.Errorf("Mode() = %q, want %q", a.Mode(), ModeBlocked)
+	}
+}
+
+func TestRunningAppsReturnsLastScan(t *testing.T) {
+	adapter := &mockAdapter{
+		procs: []process.ProcessInfo{{PID: 1, Name: "game.exe"}},
+	}
+	a := newTestAgent(&config.Config{}, "", adapter, nil)
+
+	if apps := a.RunningApps(); len(apps) != 0 {
+		t.Fatalf("RunningApps() before scan = %v, want empty", apps)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.Start(ctx)
+
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for len(a.RunningApps()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if apps := a.RunningApps(); len(apps) != 1 || apps[0].Name != "game.exe" {
+		t.Errorf("RunningApps() = %v, want [game.exe]", apps)
+	}
+}
+
 func TestStartPublishesRunningApps(t *testing.T) {
 	adapter := &mockAdapter{
 		procs: []process.ProcessInfo{
@@ -245,3 +284,54 @@ func TestSetBlacklistUpdatesKillLoop(t *testing.T) {
 		t.Error("expected new blacklist to trigger kills")
 	}
 }
+
+func TestStartPublishesRunningBlacklisted(t *testing.T) {
+	adapter := &mockAdapter{
+		procs: []process.ProcessInfo{
+			{PID: 1, Name: "Roblox.exe"},
+			{PID: 2, Name: "chrome.exe"},
+		},
+	}
+	cfg := &config.Config{Blacklist: []string{"roblox.exe", "discord.exe"}}
+
+	ch := make(chan []string, 1)
+	a := newTestAgent(cfg, "", adapter, nil)
+	a.SetOnPublishBlacklisted(func(names []string) {
+		select {
+		case ch <- names:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.Start(ctx)
+
+	select {
+	case names := <-ch:
+		if len(names) != 1 || names[0] != "roblox.exe" {
+			t.Errorf("published = %v, want [roblox.exe]", names)
+		}
+		if got := a.RunningBlacklisted(); len(got) != 1 {
+			t.Errorf("RunningBlacklisted() = %v, want [roblox.exe]", got)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timeout: aucune app interdite publiée")
+	}
+}
+
+func TestSetDiscoveryPrefixPersists(t *testing.T) {
+	path := t.TempDir() + "/config.json"
+	a := newTestAgent(&config.Config{}, path, &mockAdapter{}, nil)
+
+	if err := a.SetDiscoveryPrefix("ha"); err != nil {
+		t.Fatalf("SetDiscoveryPrefix() error = %v", err)
+	}
+	loaded, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Topics.DiscoveryPrefix != "ha" {
+		t.Errorf("saved discovery prefix = %q, want %q", loaded.Topics.DiscoveryPrefix, "ha")
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"home-guard/internal/config"
+)
+
+const (
+	maxUsageGap = 30 * time.Second
+	usageFile   = "quota_usage.json"
+)
+
+type usageRecord struct {
+	Day     string `json:"day"`
+	Seconds int64  `json:"seconds"`
+	Warned  bool   `json:"warned,omitempty"`
+}
+
+type QuotaState struct {
+	DailyMinutes   int `json:"daily_minutes"`
+	WarningMinutes int `json:"warning_minutes"`
+	UsedMinutes    int `json:"used_minutes"`
+}
+
+func (a *Agent) SetQuota(dailyMinutes, warningMinutes int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.cfg.Quota = config.QuotaConfig{DailyMinutes: dailyMinutes, WarningMinutes: warningMinutes}
+	a.warned = false
+
+	return config.Save(a.configPath, a.cfg)
+}
+
+func (a *Agent) Quota() QuotaState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return QuotaState{
+		DailyMinutes:   a.cfg.Quota.DailyMinutes,
+		WarningMinutes: a.cfg.Quota.WarningMinutes,
+		UsedMinutes:    int(a.usage / time.Minute),
+	}
+}
+
+func (a *Agent) trackUsage(running []string) {
+	now := a.now()
+
+	a.mu.Lock()
+	if !a.usageLoaded {
+		a.loadUsage()
+		a.usageLoaded = true
+	}
+	day, minutes, warned := a.usageDay, a.usage/time.Minute, a.warned
+	if today := now.Format(time.DateOnly); today != a.usageDay {
+		a.usageDay = today
+		a.usage = 0
+		a.warned = false
+	}
+	if len(running) > 0 && !a.lastScan.IsZero() {
+		a.usage += min(now.Sub(a.lastScan), maxUsageGap)
+	}
+	a.lastScan = now
+
+	limit := time.Duration(a.cfg.Quota.DailyMinutes) * time.Minute
+	warning := time.Duration(a.cfg.Quota.WarningMinutes) * time.Minute
+	remaining := limit - a.usage
+	exceeded := limit > 0 && remaining <= 0
+	warn := limit > 0 && warning > 0 && !a.warned && !exceeded && remaining <= warning
+	if warn {
+		a.warned = true
+	}
+	if day != a.usageDay || minutes != a.usage/time.Minute || warned != a.warned {
+		a.saveUsage()
+	}
+	a.mu.Unlock()
+
+	if warn && a.onQuotaWarning != nil {
+		a.onQuotaWarning(remaining)
+	}
+	if exceeded && len(running) > 0 {
+		a.manager.KillAll(running)
+	}
+}
+
+func (a *Agent) usagePath() string {
+	if a.configPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(a.configPath), usageFile)
+}
+
+func (a *Agent) loadUsage() {
+	path := a.usagePath()
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("quota: failed to read %s: %v", usageFile, err)
+		}
+		return
+	}
+	var r usageRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		log.Printf("quota: invalid %s: %v", usageFile, err)
+		return
+	}
+	if r.Day != a.now().Format(time.DateOnly) {
+		return
+	}
+	a.usageDay = r.Day
+	a.usage = time.Duration(r.Seconds) * time.Second
+	a.warned = r.Warned
+}
+
+func (a *Agent) saveUsage() {
+	path := a.usagePath()
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(usageRecord{Day: a.usageDay, Seconds: int64(a.usage / time.Second), Warned: a.warned})
+	if err == nil {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		log.Printf("quota: failed to write %s: %v", usageFile, err)
+	}
+}
@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"home-guard/internal/config"
+	"home-guard/internal/process"
+)
+
+func TestQuotaWarnsThenKills(t *testing.T) {
+	adapter := &mockAdapter{
+		procs: []process.ProcessInfo{{PID: 1, Name: "roblox.exe"}},
+	}
+	cfg := &config.Config{
+		Blacklist: []string{"roblox.exe"},
+		Quota:     config.QuotaConfig{DailyMinutes: 1, WarningMinutes: 1},
+	}
+	a := newTestAgent(cfg, "", adapter, nil)
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+	var warnings int
+	a.SetOnQuotaWarning(func(time.Duration) { warnings++ })
+
+	running := []string{"roblox.exe"}
+	for range 5 {
+		a.trackUsage(running)
+		now = now.Add(10 * time.Second)
+	}
+	if warnings != 1 {
+		t.Errorf("warnings = %d, want 1", warnings)
+	}
+	if len(adapter.killed) != 0 {
+		t.Fatalf("killed = %v before quota is reached", adapter.killed)
+	}
+
+	for range 3 {
+		a.trackUsage(running)
+		now = now.Add(10 * time.Second)
+	}
+	if len(adapter.killed) == 0 {
+		t.Error("expected blacklisted app to be killed once the quota is reached")
+	}
+	if q := a.Quota(); q.UsedMinutes != 1 {
+		t.Errorf("UsedMinutes = %d, want 1", q.UsedMinutes)
+	}
+
+	now = now.Add(24 * time.Hour)
+	a.trackUsage(nil)
+	if q := a.Quota(); q.UsedMinutes != 0 {
+		t.Errorf("UsedMinutes after midnight = %d, want 0", q.UsedMinutes)
+	}
+}
+
+func TestSetQuotaPersists(t *testing.T) {
+	path := t.TempDir() + "/config.json"
+	cfg := &config.Config{}
+	a := newTestAgent(cfg, path, &mockAdapter{}, nil)
+
+	if err := a.SetQuota(90, 10); err != nil {
+		t.Fatalf("SetQuota() error = %v", err)
+	}
+	loaded, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Quota.DailyMinutes != 90 || loaded.Quota.WarningMinutes != 10 {
+		t.Errorf("saved quota = %+v, want 90/10", loaded.Quota)
+	}
+}
+
+func TestQuotaCountsOnlyWhileBlacklistedRunning(t *testing.T) {
+	a := newTestAgent(&config.Config{Quota: config.QuotaConfig{DailyMinutes: 60}}, "", &mockAdapter{}, nil)
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+
+	for range 6 {
+		a.trackUsage(nil)
+		now = now.Add(10 * time.Second)
+	}
+	if q := a.Quota(); q.UsedMinutes != 0 {
+		t.Errorf("UsedMinutes = %d with no blacklisted app running, want 0", q.UsedMinutes)
+	}
+
+	for range 7 {
+		a.trackUsage([]string{"roblox.exe"})
+		now = now.Add(10 * time.Second)
+	}
+	if q := a.Quota(); q.UsedMinutes != 1 {
+		t.Errorf("UsedMinutes = %d, want 1", q.UsedMinutes)
+	}
+}
+
+func TestQuotaCapsScanGaps(t *testing.T) {
+	a := newTestAgent(&config.Config{Quota: config.QuotaConfig{DailyMinutes: 60}}, "", &mockAdapter{}, nil)
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+
+	a.trackUsage([]string{"roblox.exe"})
+	now = now.Add(time.Hour)
+	a.trackUsage([]string{"roblox.exe"})
+
+	if a.usage != maxUsageGap {
+		t.Errorf("usage = %s after a long gap, want %s", a.usage, maxUsageGap)
+	}
+}
+
+func TestQuotaDisabledNeverKills(t *testing.T) {
+	adapter := &mockAdapter{procs: []process.ProcessInfo{{PID: 1, Name: "roblox.exe"}}}
+	a := newTestAgent(&config.Config{Blacklist: []string{"roblox.exe"}}, "", adapter, nil)
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+	var warnings int
+	a.SetOnQuotaWarning(func(time.Duration) { warnings++ })
+
+	for range 100 {
+		a.trackUsage([]string{"roblox.exe"})
+		now = now.Add(30 * time.Second)
+	}
+	if len(adapter.killed) != 0 || warnings != 0 {
+		t.Errorf("killed = %v, warnings = %d, want nothing without a daily quota", adapter.killed, warnings)
+	}
+}
+
+func TestSetQuotaRearmsWarning(t *testing.T) {
+	a := newTestAgent(&config.Config{Quota: config.QuotaConfig{DailyMinutes: 10, WarningMinutes: 10}}, t.TempDir()+"/config.json", &mockAdapter{}, nil)
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+	var warnings int
+	a.SetOnQuotaWarning(func(time.Duration) { warnings++ })
+
+	a.trackUsage([]string{"roblox.exe"})
+	if warnings != 1 {
+		t.Fatalf("warnings = %d, want 1", warnings)
+	}
+	if err := a.SetQuota(10, 10); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(10 * time.Second)
+	a.trackUsage([]string{"roblox.exe"})
+	if warnings != 2 {
+		t.Errorf("warnings = %d after changing the quota, want a new warning", warnings)
+	}
+}
+
+func TestQuotaUsageSurvivesRestart(t *testing.T) {
+	path := t.TempDir() + "/config.json"
+	cfg := &config.Config{
+		Blacklist: []string{"roblox.exe"},
+		Quota:     config.QuotaConfig{DailyMinutes: 60},
+	}
+	now := time.Date(2024, 6, 1, 14, 0, 0, 0, time.Local)
+	running := []string{"roblox.exe"}
+
+	a := newTestAgent(cfg, path, &mockAdapter{}, nil)
+	a.now = func() time.Time { return now }
+	for range 13 {
+		a.trackUsage(running)
+		now = now.Add(10 * time.Second)
+	}
+	if q := a.Quota(); q.UsedMinutes != 2 {
+		t.Fatalf("UsedMinutes = %d, want 2", q.UsedMinutes)
+	}
+
+	restarted := newTestAgent(cfg, path, &mockAdapter{}, nil)
+	restarted.now = func() time.Time { return now }
+	restarted.trackUsage(running)
+	if q := restarted.Quota(); q.UsedMinutes != 2 {
+		t.Errorf("UsedMinutes after restart = %d, want 2", q.UsedMinutes)
+	}
+
+	now = now.Add(24 * time.Hour)
+	nextDay := newTestAgent(cfg, path, &mockAdapter{}, nil)
+	nextDay.now = func() time.Time { return now }
+	nextDay.trackUsage(running)
+	if q := nextDay.Quota(); q.UsedMinutes != 0 {
+		t.Errorf("UsedMinutes on the next day = %d, want 0", q.UsedMinutes)
+	}
+}
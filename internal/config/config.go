@@ -2,16 +2,88 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 )
 
+const DefaultPort = 1883
+
+var schemePorts = map[string]int{
+	"tcp":   1883,
+	"mqtt":  1883,
+	"ssl":   8883,
+	"tls":   8883,
+	"mqtts": 8883,
+	"ws":    80,
+	"wss":   443,
+}
+
 type Config struct {
-	Broker    string   `json:"broker"`
-	Port      int      `json:"port"`
-	Username  string   `json:"username"`
-	Password  string   `json:"password"`
-	ClientID  string   `json:"client_id"`
-	Blacklist []string `json:"blacklist"`
+	Broker     string           `json:"broker"`
+	Brokers    []string         `json:"brokers,omitempty"`
+	Port       int              `json:"port"`
+	Username   string           `json:"username"`
+	Password   string           `json:"password"`
+	ClientID   string           `json:"client_id"`
+	Blacklist  []string         `json:"blacklist"`
+	Topics     TopicConfig      `json:"topics,omitzero"`
+	Auth       AuthConfig       `json:"auth,omitzero"`
+	Encryption EncryptionConfig `json:"encryption,omitzero"`
+	MQTT       MQTTConfig       `json:"mqtt,omitzero"`
+	Quota      QuotaConfig      `json:"quota,omitzero"`
+	Update     UpdateConfig     `json:"update,omitzero"`
+}
+
+type TopicConfig struct {
+	Prefix          string `json:"prefix,omitempty"`
+	Stat            string `json:"stat,omitempty"`
+	Cmnd            string `json:"cmnd,omitempty"`
+	DiscoveryPrefix string `json:"discovery_prefix,omitempty"`
+}
+
+type AuthConfig struct {
+	Secret       string `json:"secret,omitempty"`
+	ReplayWindow int    `json:"replay_window,omitempty"`
+}
+
+type EncryptionConfig struct {
+	Keys   map[string]string `json:"keys,omitempty"`
+	KeyID  string            `json:"key_id,omitempty"`
+	Topics []string          `json:"topics,omitempty"`
+}
+
+type MQTTConfig struct {
+	Protocol      int `json:"protocol,omitempty"`
+	SessionExpiry int `json:"session_expiry,omitempty"`
+	NotifyExpiry  int `json:"notify_expiry,omitempty"`
+}
+
+type QuotaConfig struct {
+	DailyMinutes   int `json:"daily_minutes,omitempty"`
+	WarningMinutes int `json:"warning_minutes,omitempty"`
+}
+
+type UpdateConfig struct {
+	Source     string `json:"source,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Channel    string `json:"channel,omitempty"`
+	Pin        string `json:"pin,omitempty"`
+	MinVersion string `json:"min_version,omitempty"`
+	MaxVersion string `json:"max_version,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+	Proxy      string `json:"proxy,omitempty"`
+	Token      string `json:"token,omitempty"`
+
+	Window                  string `json:"window,omitempty"`
+	DeferBlocked            bool   `json:"defer_blocked,omitempty"`
+	DeferRunningBlacklisted bool   `json:"defer_running_blacklisted,omitempty"`
+	DeferUserActive         bool   `json:"defer_user_active,omitempty"`
+	MaxDeferral             int    `json:"max_deferral,omitempty"`
 }
 
 func Load(path string) (*Config, error) {
@@ -35,3 +107,62 @@ func Save(path string, cfg *Config) error {
 	}
 	return os.WriteFile(path, data, 0644)
 }
+
+func (c *Config) BrokerURL() (string, error) {
+	urls, err := c.BrokerURLs()
+	if err != nil {
+		return "", err
+	}
+	return urls[0], nil
+}
+
+func (c *Config) BrokerURLs() ([]string, error) {
+	brokers := c.Brokers
+	if len(brokers) == 0 {
+		brokers = []string{c.Broker}
+	}
+
+	urls := make([]string, 0, len(brokers))
+	for _, b := range brokers {
+		u, err := brokerURL(b, c.Port)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+func brokerURL(broker string, port int) (string, error) {
+	broker = strings.TrimSpace(broker)
+	if broker == "" {
+		return "", fmt.Errorf("broker is empty")
+	}
+
+	if !strings.Contains(broker, "://") {
+		if port == 0 {
+			port = DefaultPort
+		}
+		return "tcp://" + net.JoinHostPort(broker, strconv.Itoa(port)), nil
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", fmt.Errorf("invalid broker URL %q: %w", broker, err)
+	}
+
+	defaultPort, ok := schemePorts[strings.ToLower(u.Scheme)]
+	if !ok {
+		return "", fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("broker URL %q has no host", broker)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(defaultPort))
+	}
+
+	return u.String(), nil
+}
@@ -102,3 +102,82 @@ func TestSave(t *testing.T) {
 		t.Errorf("Blacklist = %v, want [game.exe]", loaded.Blacklist)
 	}
 }
+
+func TestBrokerURL(t *testing.T) {
+	cases := []struct {
+		broker string
+		port   int
+		want   string
+	}{
+		{"localhost", 1883, "tcp://localhost:1883"},
+		{"192.168.1.10", 0, "tcp://192.168.1.10:1883"},
+		{"tcp://broker.lan:1884", 1883, "tcp://broker.lan:1884"},
+		{"ssl://broker.lan", 1883, "ssl://broker.lan:8883"},
+		{"ws://broker.lan:9001", 0, "ws://broker.lan:9001"},
+		{"wss://example.org/mqtt", 1883, "wss://example.org:443/mqtt"},
+	}
+
+	for _, tc := range cases {
+		cfg := &Config{Broker: tc.broker, Port: tc.port}
+		got, err := cfg.BrokerURL()
+		if err != nil {
+			t.Errorf("BrokerURL(%q) error = %v", tc.broker, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("BrokerURL(%q) = %q, want %q", tc.broker, got, tc.want)
+		}
+	}
+}
+
+func TestBrokerURLInvalid(t *testing.T) {
+	for _, broker := range []string{"", "http://broker.lan", "ws:///mqtt"} {
+		cfg := &Config{Broker: broker}
+		if _, err := cfg.BrokerURL(); err == nil {
+			t.Errorf("BrokerURL(%q) expected error, got nil", broker)
+		}
+	}
+}
+
+func TestBrokerURLs(t *testing.T) {
+	cfg := &Config{
+		Broker:  "ignored.lan",
+		Brokers: []string{"homeassistant.lan", "tcp://backup.lan:1884", "homeassistant.lan"},
+		Port:    1883,
+	}
+
+	urls, err := cfg.BrokerURLs()
+	if err != nil {
+		t.Fatalf("BrokerURLs() error = %v", err)
+	}
+
+	want := []string{"tcp://homeassistant.lan:1883", "tcp://backup.lan:1884"}
+	if len(urls) != len(want) {
+		t.Fatalf("BrokerURLs() = %v, want %v", urls, want)
+	}
+	for i := range want {
+		if urls[i] != want[i] {
+			t.Errorf("BrokerURLs()[%d] = %q, want %q", i, urls[i], want[i])
+		}
+	}
+
+	primary, err := cfg.BrokerURL()
+	if err != nil {
+		t.Fatalf("BrokerURL() error = %v", err)
+	}
+	if primary != want[0] {
+		t.Errorf("BrokerURL() = %q, want %q", primary, want[0])
+	}
+}
+
+func TestBrokerURLsFallsBackToBroker(t *testing.T) {
+	cfg := &Config{Broker: "localhost", Port: 1883}
+
+	urls, err := cfg.BrokerURLs()
+	if err != nil {
+		t.Fatalf("BrokerURLs() error = %v", err)
+	}
+	if len(urls) != 1 || urls[0] != "tcp://localhost:1883" {
+		t.Errorf("BrokerURLs() = %v, want [tcp://localhost:1883]", urls)
+	}
+}
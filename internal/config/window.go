@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MaintenanceWindow struct {
+	start, end time.Duration
+}
+
+func ParseWindow(s string) (MaintenanceWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return MaintenanceWindow{}, fmt.Errorf("invalid maintenance window %q", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	if start == end {
+		return MaintenanceWindow{}, fmt.Errorf("invalid maintenance window %q: empty range", s)
+	}
+	return MaintenanceWindow{start: start, end: end}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.start <= w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2026, 3, 10, hour, minute, 0, 0, time.Local)
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	cases := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"02:00-05:00", at(3, 0), true},
+		{"02:00-05:00", at(2, 0), true},
+		{"02:00-05:00", at(5, 0), false},
+		{"02:00-05:00", at(14, 30), false},
+		{"22:00-06:00", at(23, 15), true},
+		{"22:00-06:00", at(1, 0), true},
+		{"22:00-06:00", at(12, 0), false},
+	}
+	for _, tc := range cases {
+		w, err := ParseWindow(tc.window)
+		if err != nil {
+			t.Fatalf("ParseWindow(%q) error = %v", tc.window, err)
+		}
+		if got := w.Contains(tc.t); got != tc.want {
+			t.Errorf("%s contains %s = %v, want %v", tc.window, tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"02:00", "2h-5h", "02:00-25:00", "02:00-02:00"} {
+		if _, err := ParseWindow(bad); err == nil {
+			t.Errorf("ParseWindow(%q) expected error", bad)
+		}
+	}
+}
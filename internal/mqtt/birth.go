@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+const (
+	birthMinDelay = time.Second
+	birthJitter   = 4 * time.Second
+)
+
+func birthDelay() time.Duration {
+	return birthMinDelay + rand.N(birthJitter)
+}
+
+func (c *Client) SetOnBirth(fn func()) {
+	c.onBirth = fn
+}
+
+func (c *Client) SetDiscoveryPrefix(prefix string) {
+	c.mu.Lock()
+	old := c.topics
+	c.topics = c.topics.WithDiscoveryPrefix(prefix)
+	changed := c.topics.DiscoveryPrefix() != old.DiscoveryPrefix()
+	if changed && c.cipher != nil {
+		cipher, err := newPayloadCipher(c.cfg.Encryption, c.topics)
+		if err != nil {
+			log.Printf("mqtt: failed to rebuild payload cipher: %v", err)
+		} else {
+			c.cipher = cipher
+		}
+	}
+	c.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	log.Printf("mqtt: discovery prefix changed from %s to %s", old.DiscoveryPrefix(), prefix)
+	if err := c.Unsubscribe(birthTopic(old)); err != nil {
+		log.Printf("failed to unsubscribe from %s: %v", birthTopic(old), err)
+	}
+	c.clearDiscovery(old)
+	if err := c.watchBirth(); err != nil {
+		log.Printf("failed to subscribe to %s: %v", birthTopic(c.Topics()), err)
+	}
+	c.scheduleResync()
+}
+
+func birthTopic(t Topics) string {
+	return t.DiscoveryPrefix() + "/status"
+}
+
+func (c *Client) watchBirth() error {
+	return c.Subscribe(birthTopic(c.Topics()), func(payload []byte) {
+		if strings.TrimSpace(string(payload)) == "online" {
+			log.Printf("mqtt: Home Assistant is online")
+			c.scheduleResync()
+		}
+	})
+}
+
+func (c *Client) scheduleResync() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resyncPending {
+		return
+	}
+	c.resyncPending = true
+	time.AfterFunc(c.birthDelay(), c.resync)
+}
+
+func (c *Client) resync() {
+	c.mu.Lock()
+	c.resyncPending = false
+	c.mu.Unlock()
+
+	if c.onBirth != nil {
+		c.onBirth()
+		return
+	}
+	if err := c.PublishDiscovery(); err != nil {
+		log.Printf("failed to publish HA discovery: %v", err)
+	}
+}
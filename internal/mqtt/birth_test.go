@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"home-guard/internal/config"
+)
+
+func TestBirthMessageTriggersResync(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	client.birthDelay = func() time.Duration { return 0 }
+	births := make(chan struct{}, 4)
+	client.SetOnBirth(func() { births <- struct{}{} })
+	_ = client.Connect()
+	mock.onConnectHandler(mock)
+
+	handler := mock.subscriptions["homeassistant/status"]
+	if handler == nil {
+		t.Fatal("expected subscription to homeassistant/status")
+	}
+
+	handler(mock, &mockMessage{topic: "homeassistant/status", payload: []byte("offline")})
+	handler(mock, &mockMessage{topic: "homeassistant/status", payload: []byte("online")})
+
+	select {
+	case <-births:
+	case <-time.After(time.Second):
+		t.Fatal("expected resync after HA birth message")
+	}
+	select {
+	case <-births:
+		t.Error("unexpected second resync")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSetDiscoveryPrefixRebuildsCipher(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encryption = config.EncryptionConfig{Keys: map[string]string{"k1": testKey1}}
+	client, _ := newTestClient(cfg)
+	defer client.Disconnect()
+	_ = client.Connect()
+
+	client.SetDiscoveryPrefix("ha")
+
+	if got := client.currentCipher().exclude; got != "ha/" {
+		t.Errorf("cipher exclude = %q, want %q", got, "ha/")
+	}
+}
+
+func TestSetDiscoveryPrefixMovesDiscovery(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	client.birthDelay = func() time.Duration { return 0 }
+	_ = client.Connect()
+	mock.onConnectHandler(mock)
+	waitPublished(t, mock, 1)
+
+	client.SetDiscoveryPrefix("ha")
+
+	if got := client.Topics().DiscoveryPrefix(); got != "ha" {
+		t.Errorf("DiscoveryPrefix() = %q, want %q", got, "ha")
+	}
+	mock.mu.Lock()
+	_, subscribed := mock.subscriptions["ha/status"]
+	mock.mu.Unlock()
+	if !subscribed {
+		t.Error("expected subscription to ha/status")
+	}
+
+	entries := len(client.discoveryEntries(client.Topics()))
+	published := waitPublished(t, mock, 1+2*entries)
+	var cleared, republished bool
+	for _, m := range published {
+		if m.topic == "homeassistant/select/test-pc/mode/config" && m.payload == "" {
+			cleared = true
+		}
+		if m.topic == "ha/select/test-pc/mode/config" && m.payload != "" {
+			republished = true
+		}
+	}
+	if !cleared || !republished {
+		t.Errorf("published = %v, want old discovery cleared and new one published", published)
+	}
+}
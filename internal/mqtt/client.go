@@ -1,10 +1,16 @@
 package mqtt
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	pahomqtt "github.com/eclipse/paho.mqtt.golang"
@@ -17,15 +23,49 @@ const (
 	baseDelay      = time.Second
 	maxDelay       = 2 * time.Minute
 	maxRetries     = 12
+
+	primaryCheckInterval = time.Minute
+	primaryProbeTimeout  = 5 * time.Second
+	maxFailbackDelay     = time.Hour
 )
 
 type pahoFactory func(*pahomqtt.ClientOptions) pahomqtt.Client
 
 type Client struct {
 	cfg       *config.Config
-	paho      pahomqtt.Client
+	topics    Topics
+	conn      transport
 	factory   pahoFactory
 	onConnect func()
+	onBirth   func()
+	probe     func(addr string) error
+	verifier  *verifier
+	cipher    *payloadCipher
+	outbox    *outbox
+	flushMu   sync.Mutex
+	passive   bool
+	connID    string
+
+	publishTimeout time.Duration
+	purgeTimeout   time.Duration
+	onPublishError func(topic string, err error)
+	birthDelay     func() time.Duration
+
+	mu         sync.Mutex
+	servers    []string
+	attempting string
+	current    string
+	failback   time.Duration
+	nextProbe  time.Time
+	version    string
+	stats      PublishStats
+	subs       []subscription
+	connects   int
+
+	resyncPending bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewClient(cfg *config.Config) *Client {
@@ -33,7 +73,68 @@ func NewClient(cfg *config.Config) *Client {
 }
 
 func newClientWithFactory(cfg *config.Config, factory pahoFactory) *Client {
-	return &Client{cfg: cfg, factory: factory}
+	c := &Client{
+		cfg:     cfg,
+		topics:  NewTopics(cfg.Topics, cfg.ClientID),
+		factory: factory,
+		probe:   dialProbe,
+		outbox:  newOutbox(defaultOutboxLimit),
+
+		publishTimeout: defaultPublishTimeout,
+		purgeTimeout:   defaultPurgeTimeout,
+		birthDelay:     birthDelay,
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if err := c.watchBirth(); err != nil {
+		log.Printf("failed to subscribe to %s: %v", birthTopic(c.topics), err)
+	}
+	if cfg.Auth.Secret != "" {
+		c.verifier = newVerifier(cfg.Auth.Secret, time.Duration(cfg.Auth.ReplayWindow)*time.Second)
+	}
+	return c
+}
+
+func NewPassiveClient(cfg *config.Config, role string) *Client {
+	c := NewClient(cfg)
+	c.setPassive(role)
+	return c
+}
+
+func (c *Client) setPassive(role string) {
+	c.passive = true
+	c.connID = c.cfg.ClientID + "-" + role
+	_ = c.Unsubscribe(birthTopic(c.topics))
+}
+
+func (c *Client) clientID() string {
+	if c.connID != "" {
+		return c.connID
+	}
+	return c.cfg.ClientID
+}
+
+func dialProbe(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, primaryProbeTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *Client) Topics() Topics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topics
+}
+
+func (c *Client) currentCipher() *payloadCipher {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cipher
+}
+
+func (c *Client) SetOutboxPath(path string) error {
+	return c.outbox.load(path)
 }
 
 func (c *Client) SetOnConnect(fn func()) {
@@ -41,9 +142,49 @@ func (c *Client) SetOnConnect(fn func()) {
 }
 
 func (c *Client) Connect() error {
-	opts := c.buildOptions()
-	c.paho = c.factory(opts)
+	cipher, err := newPayloadCipher(c.cfg.Encryption, c.Topics())
+	if err != nil {
+		return fmt.Errorf("encryption: %w", err)
+	}
+	c.mu.Lock()
+	c.cipher = cipher
+	c.mu.Unlock()
 
+	switch c.cfg.MQTT.Protocol {
+	case 0, 3:
+		opts, err := c.buildOptions()
+		if err != nil {
+			return err
+		}
+		c.conn = &pahoTransport{client: c.factory(opts)}
+	case 5:
+		brokers, err := c.cfg.BrokerURLs()
+		if err != nil {
+			return err
+		}
+		c.servers = brokers
+		conn, err := c.newV5Transport()
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	default:
+		return fmt.Errorf("unsupported MQTT protocol version %d", c.cfg.MQTT.Protocol)
+	}
+
+	if err := c.connectWithRetry(); err != nil {
+		return err
+	}
+
+	go c.runSender()
+
+	if len(c.servers) > 1 {
+		go c.watchPrimary()
+	}
+	return nil
+}
+
+func (c *Client) connectWithRetry() error {
 	for attempt := 0; ; attempt++ {
 		if attempt > 0 {
 			delay := exponentialDelay(attempt)
@@ -51,21 +192,88 @@ func (c *Client) Connect() error {
 			time.Sleep(delay)
 		}
 
-		token := c.paho.Connect()
-		if !token.WaitTimeout(connectTimeout) {
-			log.Printf("MQTT connect timeout (attempt %d)", attempt+1)
-		} else if err := token.Error(); err != nil {
-			log.Printf("MQTT connect error (attempt %d): %v", attempt+1, err)
-		} else {
+		ctx, cancel := context.WithTimeout(c.ctx, connectTimeout)
+		err := c.conn.connect(ctx)
+		cancel()
+		if err == nil {
 			return nil
 		}
+		log.Printf("MQTT connect error (attempt %d): %v", attempt+1, err)
 
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
 		if attempt >= maxRetries {
 			return fmt.Errorf("failed to connect to MQTT broker after %d attempts", maxRetries+1)
 		}
 	}
 }
 
+func (c *Client) CurrentBroker() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+func (c *Client) watchPrimary() {
+	ticker := time.NewTicker(primaryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkPrimary()
+		}
+	}
+}
+
+func (c *Client) checkPrimary() {
+	primary := c.servers[0]
+	current := c.CurrentBroker()
+	if current == "" || current == primary || !c.conn.isConnected() {
+		return
+	}
+	c.mu.Lock()
+	wait := time.Now().Before(c.nextProbe)
+	c.mu.Unlock()
+	if wait {
+		return
+	}
+
+	u, err := url.Parse(primary)
+	if err != nil {
+		return
+	}
+	if err := c.probe(u.Host); err != nil {
+		return
+	}
+
+	log.Printf("MQTT primary broker %s is back, leaving %s", primary, current)
+	c.conn.disconnect()
+	for {
+		err := c.connectWithRetry()
+		if err == nil {
+			break
+		}
+		log.Printf("MQTT failback failed: %v", err)
+		if c.ctx.Err() != nil {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == primary {
+		c.failback = 0
+		return
+	}
+	c.failback = min(max(2*c.failback, primaryCheckInterval), maxFailbackDelay)
+	c.nextProbe = time.Now().Add(c.failback)
+	log.Printf("MQTT primary broker %s refused the connection, next failback in %s", primary, c.failback)
+}
+
 func exponentialDelay(attempt int) time.Duration {
 	delay := float64(baseDelay) * math.Pow(2, float64(attempt-1))
 	if delay > float64(maxDelay) {
@@ -75,169 +283,544 @@ func exponentialDelay(attempt int) time.Duration {
 }
 
 func (c *Client) PublishStatus(status string) error {
-	topic := fmt.Sprintf("stat/%s/status", c.cfg.ClientID)
-	token := c.paho.Publish(topic, 1, true, status)
-	token.Wait()
-	return token.Error()
+	topic := c.Topics().Stat("status")
+	return c.publish(topic, true, []byte(status))
 }
 
 func (c *Client) Publish(topic string, payload string) error {
-	token := c.paho.Publish(topic, 1, true, payload)
-	token.Wait()
-	return token.Error()
+	return c.publish(topic, true, []byte(payload))
+}
+
+func (c *Client) publish(topic string, retained bool, payload []byte) error {
+	return c.publishEntry(outboxEntry{Topic: topic, Payload: payload, Retained: retained})
 }
 
+func (c *Client) publishEntry(e outboxEntry) error {
+	// An empty retained payload clears the topic and must stay empty.
+	if cipher := c.currentCipher(); len(e.Payload) > 0 && cipher.applies(e.Topic) {
+		sealed, err := cipher.seal(e.Topic, e.Payload)
+		if err != nil {
+			return fmt.Errorf("encrypt %s: %w", e.Topic, err)
+		}
+		e.Payload = sealed
+	}
+
+	c.outbox.enqueue(e)
+	return nil
+}
+
+const projectURL = "https://github.com/gamachec/home-guard"
+
 type haDevice struct {
-	Identifiers  []string `json:"identifiers"`
-	Name         string   `json:"name,omitempty"`
-	Model        string   `json:"model,omitempty"`
-	Manufacturer string   `json:"manufacturer,omitempty"`
+	Identifiers      []string `json:"identifiers"`
+	Name             string   `json:"name,omitempty"`
+	Model            string   `json:"model,omitempty"`
+	Manufacturer     string   `json:"manufacturer,omitempty"`
+	SWVersion        string   `json:"sw_version,omitempty"`
+	ConfigurationURL string   `json:"configuration_url,omitempty"`
+}
+
+type haEntity struct {
+	Name                string   `json:"name"`
+	UniqueID            string   `json:"unique_id"`
+	ObjectID            string   `json:"object_id"`
+	Icon                string   `json:"icon,omitempty"`
+	EntityCategory      string   `json:"entity_category,omitempty"`
+	AvailabilityTopic   string   `json:"availability_topic,omitempty"`
+	PayloadAvailable    string   `json:"payload_available,omitempty"`
+	PayloadNotAvailable string   `json:"payload_not_available,omitempty"`
+	Device              haDevice `json:"device"`
 }
 
 type haSelectDiscovery struct {
-	Name         string   `json:"name"`
-	UniqueID     string   `json:"unique_id"`
+	haEntity
 	CommandTopic string   `json:"command_topic"`
 	StateTopic   string   `json:"state_topic"`
 	Options      []string `json:"options"`
-	Device       haDevice `json:"device"`
 }
 
 type haBinarySensorDiscovery struct {
-	Name        string   `json:"name"`
-	UniqueID    string   `json:"unique_id"`
-	DeviceClass string   `json:"device_class"`
-	StateTopic  string   `json:"state_topic"`
-	PayloadOn   string   `json:"payload_on"`
-	PayloadOff  string   `json:"payload_off"`
-	Device      haDevice `json:"device"`
+	haEntity
+	DeviceClass string `json:"device_class"`
+	StateTopic  string `json:"state_topic"`
+	PayloadOn   string `json:"payload_on"`
+	PayloadOff  string `json:"payload_off"`
 }
 
 type haSensorDiscovery struct {
-	Name       string   `json:"name"`
-	UniqueID   string   `json:"unique_id"`
-	StateTopic string   `json:"state_topic"`
-	Device     haDevice `json:"device"`
+	haEntity
+	DeviceClass            string `json:"device_class,omitempty"`
+	StateTopic             string `json:"state_topic"`
+	ValueTemplate          string `json:"value_template,omitempty"`
+	UnitOfMeasurement      string `json:"unit_of_measurement,omitempty"`
+	JSONAttributesTopic    string `json:"json_attributes_topic,omitempty"`
+	JSONAttributesTemplate string `json:"json_attributes_template,omitempty"`
 }
 
-func (c *Client) PublishDiscovery() error {
+type haTextDiscovery struct {
+	haEntity
+	CommandTopic string `json:"command_topic"`
+	Max          int    `json:"max,omitempty"`
+}
+
+type haNumberDiscovery struct {
+	haEntity
+	CommandTopic      string  `json:"command_topic"`
+	StateTopic        string  `json:"state_topic"`
+	ValueTemplate     string  `json:"value_template,omitempty"`
+	Min               float64 `json:"min"`
+	Max               float64 `json:"max"`
+	Step              float64 `json:"step"`
+	Mode              string  `json:"mode,omitempty"`
+	UnitOfMeasurement string  `json:"unit_of_measurement,omitempty"`
+}
+
+type haButtonDiscovery struct {
+	haEntity
+	CommandTopic string `json:"command_topic"`
+	PayloadPress string `json:"payload_press"`
+}
+
+type haUpdateDiscovery struct {
+	haEntity
+	DeviceClass            string `json:"device_class"`
+	StateTopic             string `json:"state_topic"`
+	CommandTopic           string `json:"command_topic"`
+	PayloadInstall         string `json:"payload_install"`
+	JSONAttributesTopic    string `json:"json_attributes_topic"`
+	JSONAttributesTemplate string `json:"json_attributes_template"`
+}
+
+func (c *Client) SetVersion(version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = version
+}
+
+func (c *Client) entity(topics Topics, object, name, icon, category string) haEntity {
+	c.mu.Lock()
+	version := c.version
+	c.mu.Unlock()
+
 	id := c.cfg.ClientID
-	fullDevice := haDevice{
-		Identifiers:  []string{id},
-		Name:         id,
-		Model:        "HomeGuard",
-		Manufacturer: "HomeGuard",
+	return haEntity{
+		Name:                name,
+		UniqueID:            id + "_" + object,
+		ObjectID:            id + "_" + object,
+		Icon:                icon,
+		EntityCategory:      category,
+		AvailabilityTopic:   topics.Stat("status"),
+		PayloadAvailable:    "online",
+		PayloadNotAvailable: "offline",
+		Device: haDevice{
+			Identifiers:      []string{id},
+			Name:             id,
+			Model:            "HomeGuard",
+			Manufacturer:     "HomeGuard",
+			SWVersion:        version,
+			ConfigurationURL: projectURL,
+		},
+	}
+}
+
+type discoveryEntry struct {
+	topic   string
+	payload any
+}
+
+func (c *Client) PublishDiscovery() error {
+	for _, e := range c.discoveryEntries(c.Topics()) {
+		data, err := json.Marshal(e.payload)
+		if err != nil {
+			return err
+		}
+		if err := c.publish(e.topic, true, data); err != nil {
+			return err
+		}
 	}
-	minDevice := haDevice{Identifiers: []string{id}}
+	return nil
+}
+
+func (c *Client) clearDiscovery(topics Topics) {
+	for _, e := range c.discoveryEntries(topics) {
+		if err := c.publish(e.topic, true, nil); err != nil {
+			log.Printf("failed to clear discovery %s: %v", e.topic, err)
+		}
+	}
+}
 
-	entries := []struct {
-		topic   string
-		payload any
-	}{
+func (c *Client) discoveryEntries(topics Topics) []discoveryEntry {
+	connectivity := c.entity(topics, "online", "Etat", "", "diagnostic")
+	connectivity.AvailabilityTopic = ""
+	connectivity.PayloadAvailable = ""
+	connectivity.PayloadNotAvailable = ""
+
+	// The updater reports on its own connection, so its sensors stay
+	// available while the agent is down.
+	updater := func(object, name, icon string) haEntity {
+		e := c.entity(topics, object, name, icon, "diagnostic")
+		e.AvailabilityTopic = ""
+		e.PayloadAvailable = ""
+		e.PayloadNotAvailable = ""
+		return e
+	}
+
+	return []discoveryEntry{
 		{
-			fmt.Sprintf("homeassistant/select/%s/mode/config", id),
+			topics.Discovery("select", "mode"),
 			haSelectDiscovery{
-				Name:         "Mode d'utilisation",
-				UniqueID:     id + "_mode",
-				CommandTopic: fmt.Sprintf("cmnd/%s/mode", id),
-				StateTopic:   fmt.Sprintf("stat/%s/current_mode", id),
+				haEntity:     c.entity(topics, "mode", "Mode d'utilisation", "mdi:shield-lock", ""),
+				CommandTopic: topics.Cmnd("mode"),
+				StateTopic:   topics.Stat("current_mode"),
 				Options:      []string{"ACTIVE", "BLOCKED"},
-				Device:       fullDevice,
 			},
 		},
 		{
-			fmt.Sprintf("homeassistant/binary_sensor/%s/connectivity/config", id),
+			topics.Discovery("binary_sensor", "connectivity"),
 			haBinarySensorDiscovery{
-				Name:        "Etat",
-				UniqueID:    id + "_online",
+				haEntity:    connectivity,
 				DeviceClass: "connectivity",
-				StateTopic:  fmt.Sprintf("stat/%s/status", id),
+				StateTopic:  topics.Stat("status"),
 				PayloadOn:   "online",
 				PayloadOff:  "offline",
-				Device:      minDevice,
 			},
 		},
 		{
-			fmt.Sprintf("homeassistant/sensor/%s/apps/config", id),
+			topics.Discovery("sensor", "apps"),
 			haSensorDiscovery{
-				Name:       "Applications en cours",
-				UniqueID:   id + "_running_apps",
-				StateTopic: fmt.Sprintf("stat/%s/running_apps", id),
-				Device:     minDevice,
+				haEntity:            c.entity(topics, "running_apps", "Applications en cours", "mdi:application-outline", ""),
+				StateTopic:          topics.Stat("running_apps"),
+				UnitOfMeasurement:   "apps",
+				JSONAttributesTopic: topics.Stat("running_apps/attributes"),
 			},
 		},
 		{
-			fmt.Sprintf("homeassistant/sensor/%s/version/config", id),
+			topics.Discovery("sensor", "running_blacklisted"),
 			haSensorDiscovery{
-				Name:       "Version",
-				UniqueID:   id + "_version",
-				StateTopic: fmt.Sprintf("stat/%s/version", id),
-				Device:     minDevice,
+				haEntity:            c.entity(topics, "running_blacklisted", "Applications interdites en cours", "mdi:application-cog-outline", ""),
+				StateTopic:          topics.Stat("running_blacklisted"),
+				UnitOfMeasurement:   "apps",
+				JSONAttributesTopic: topics.Stat("running_blacklisted/attributes"),
+			},
+		},
+		{
+			topics.Discovery("text", "blacklist_add"),
+			haTextDiscovery{
+				haEntity:     c.entity(topics, "blacklist_add", "Ajouter à la blacklist", "mdi:playlist-plus", "config"),
+				CommandTopic: topics.Cmnd("blacklist/add"),
+				Max:          255,
+			},
+		},
+		{
+			topics.Discovery("text", "blacklist_remove"),
+			haTextDiscovery{
+				haEntity:     c.entity(topics, "blacklist_remove", "Retirer de la blacklist", "mdi:playlist-minus", "config"),
+				CommandTopic: topics.Cmnd("blacklist/remove"),
+				Max:          255,
+			},
+		},
+		{
+			topics.Discovery("number", "daily_minutes"),
+			haNumberDiscovery{
+				haEntity:          c.entity(topics, "daily_minutes", "Quota quotidien", "mdi:timer-sand", "config"),
+				CommandTopic:      topics.Cmnd("quota/daily_minutes"),
+				StateTopic:        topics.Stat("quota"),
+				ValueTemplate:     "{{ value_json.daily_minutes }}",
+				Max:               1440,
+				Step:              5,
+				Mode:              "box",
+				UnitOfMeasurement: "min",
+			},
+		},
+		{
+			topics.Discovery("number", "warning_minutes"),
+			haNumberDiscovery{
+				haEntity:          c.entity(topics, "warning_minutes", "Avertissement avant la fin du quota", "mdi:timer-alert-outline", "config"),
+				CommandTopic:      topics.Cmnd("quota/warning_minutes"),
+				StateTopic:        topics.Stat("quota"),
+				ValueTemplate:     "{{ value_json.warning_minutes }}",
+				Max:               60,
+				Step:              1,
+				Mode:              "box",
+				UnitOfMeasurement: "min",
+			},
+		},
+		{
+			topics.Discovery("sensor", "used_minutes"),
+			haSensorDiscovery{
+				haEntity:          c.entity(topics, "used_minutes", "Temps utilisé aujourd'hui", "mdi:timer-outline", ""),
+				StateTopic:        topics.Stat("quota"),
+				ValueTemplate:     "{{ value_json.used_minutes }}",
+				UnitOfMeasurement: "min",
+			},
+		},
+		{
+			topics.Discovery("button", "rescan"),
+			haButtonDiscovery{
+				haEntity:     c.entity(topics, "rescan", "Rescanner les applications", "mdi:refresh", ""),
+				CommandTopic: topics.Cmnd("rescan"),
+				PayloadPress: "PRESS",
+			},
+		},
+		{
+			topics.Discovery("button", "republish"),
+			haButtonDiscovery{
+				haEntity:     c.entity(topics, "republish", "Republier la discovery", "mdi:home-import-outline", "diagnostic"),
+				CommandTopic: topics.Cmnd("discovery"),
+				PayloadPress: "PRESS",
+			},
+		},
+		{
+			topics.Discovery("button", "check_update"),
+			haButtonDiscovery{
+				haEntity:     c.entity(topics, "check_update", "Rechercher une mise à jour", "mdi:update", "config"),
+				CommandTopic: topics.Cmnd("update/check"),
+				PayloadPress: "PRESS",
+			},
+		},
+		{
+			topics.Discovery("update", "agent"),
+			haUpdateDiscovery{
+				haEntity:               c.entity(topics, "agent", "Home Guard", "", "config"),
+				DeviceClass:            "firmware",
+				StateTopic:             topics.Stat("update"),
+				CommandTopic:           topics.Cmnd("update/install"),
+				PayloadInstall:         "install",
+				JSONAttributesTopic:    topics.Stat("update"),
+				JSONAttributesTemplate: "{{ {'error': value_json.error, 'checked_at': value_json.checked_at, 'deferred': value_json.deferred} | tojson }}",
+			},
+		},
+		{
+			topics.Discovery("sensor", "version"),
+			haSensorDiscovery{
+				haEntity:   c.entity(topics, "version", "Version", "mdi:tag-outline", "diagnostic"),
+				StateTopic: topics.Stat("version"),
+			},
+		},
+		{
+			topics.Discovery("sensor", "broker"),
+			haSensorDiscovery{
+				haEntity:   c.entity(topics, "broker", "Broker", "mdi:server-network", "diagnostic"),
+				StateTopic: topics.Stat("broker"),
+			},
+		},
+		{
+			topics.Discovery("sensor", "reconnects"),
+			haSensorDiscovery{
+				haEntity:   c.entity(topics, "reconnects", "Reconnexions", "mdi:connection", "diagnostic"),
+				StateTopic: topics.Stat("reconnects"),
+			},
+		},
+		{
+			topics.Discovery("sensor", "outbox"),
+			haSensorDiscovery{
+				haEntity:               c.entity(topics, "outbox", "Messages en attente", "mdi:tray-full", "diagnostic"),
+				StateTopic:             topics.Stat("outbox"),
+				ValueTemplate:          "{{ value_json.queued }}",
+				UnitOfMeasurement:      "messages",
+				JSONAttributesTopic:    topics.Stat("outbox"),
+				JSONAttributesTemplate: "{{ {'sent': value_json.sent, 'failed': value_json.failed, 'dropped': value_json.dropped, 'last_error': value_json.last_error} | tojson }}",
+			},
+		},
+		{
+			topics.Discovery("sensor", "updater_result"),
+			haSensorDiscovery{
+				haEntity:      updater("updater_result", "Résultat de la mise à jour", "mdi:update"),
+				StateTopic:    topics.Stat("updater"),
+				ValueTemplate: "{{ value_json.result | default('unknown') }}",
+			},
+		},
+		{
+			topics.Discovery("sensor", "updater_checked_at"),
+			haSensorDiscovery{
+				haEntity:      updater("updater_checked_at", "Dernière vérification", "mdi:calendar-clock"),
+				DeviceClass:   "timestamp",
+				StateTopic:    topics.Stat("updater"),
+				ValueTemplate: "{{ value_json.checked_at | default(None) }}",
+			},
+		},
+		{
+			topics.Discovery("sensor", "updater_latest_version"),
+			haSensorDiscovery{
+				haEntity:      updater("updater_latest_version", "Version disponible", "mdi:tag-arrow-up-outline"),
+				StateTopic:    topics.Stat("updater"),
+				ValueTemplate: "{{ value_json.latest_version | default('') }}",
+			},
+		},
+		{
+			topics.Discovery("sensor", "updater_progress"),
+			haSensorDiscovery{
+				haEntity:          updater("updater_progress", "Téléchargement de la mise à jour", "mdi:download"),
+				StateTopic:        topics.Stat("updater"),
+				ValueTemplate:     "{{ value_json.update_percentage | default(0) }}",
+				UnitOfMeasurement: "%",
+			},
+		},
+		{
+			topics.Discovery("sensor", "updater_error"),
+			haSensorDiscovery{
+				haEntity:      updater("updater_error", "Erreur de mise à jour", "mdi:alert-circle-outline"),
+				StateTopic:    topics.Stat("updater"),
+				ValueTemplate: "{{ (value_json.error | default(''))[:255] }}",
 			},
 		},
 	}
-
-	for _, e := range entries {
-		data, err := json.Marshal(e.payload)
-		if err != nil {
-			return err
-		}
-		token := c.paho.Publish(e.topic, 1, true, data)
-		token.Wait()
-		if err := token.Error(); err != nil {
-			return err
-		}
-	}
-	return nil
 }
 
 func (c *Client) PublishVersion(version string) error {
-	topic := fmt.Sprintf("stat/%s/version", c.cfg.ClientID)
-	token := c.paho.Publish(topic, 1, true, version)
-	token.Wait()
-	return token.Error()
+	topic := c.Topics().Stat("version")
+	return c.publish(topic, true, []byte(version))
 }
 
 func (c *Client) PublishRunningApps(apps any) error {
-	topic := fmt.Sprintf("stat/%s/running_apps", c.cfg.ClientID)
-	payload, err := json.Marshal(apps)
+	list, err := json.Marshal(apps)
+	if err != nil {
+		return err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(list, &items); err != nil {
+		return fmt.Errorf("running apps must be a list: %w", err)
+	}
+
+	attributes, err := json.Marshal(map[string]json.RawMessage{"apps": list})
+	if err != nil {
+		return err
+	}
+	topics := c.Topics()
+	if err := c.publish(topics.Stat("running_apps"), true, []byte(strconv.Itoa(len(items)))); err != nil {
+		return err
+	}
+	return c.publish(topics.Stat("running_apps/attributes"), true, attributes)
+}
+
+func (c *Client) PublishRunningBlacklisted(names []string) error {
+	if names == nil {
+		names = []string{}
+	}
+	attributes, err := json.Marshal(map[string][]string{"apps": names})
+	if err != nil {
+		return err
+	}
+	topics := c.Topics()
+	if err := c.publish(topics.Stat("running_blacklisted"), true, []byte(strconv.Itoa(len(names)))); err != nil {
+		return err
+	}
+	return c.publish(topics.Stat("running_blacklisted/attributes"), true, attributes)
+}
+
+func (c *Client) PublishQuota(state any) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return c.publish(c.Topics().Stat("quota"), true, payload)
+}
+
+func (c *Client) PublishOutbox(stats PublishStats) error {
+	payload, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return c.publish(c.Topics().Stat("outbox"), true, payload)
+}
+
+func (c *Client) PublishUpdate(state any) error {
+	payload, err := json.Marshal(state)
 	if err != nil {
 		return err
 	}
-	token := c.paho.Publish(topic, 1, true, payload)
-	token.Wait()
-	return token.Error()
+	return c.publish(c.Topics().Stat("update"), true, payload)
+}
+
+func (c *Client) PublishUpdater(state any) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return c.publish(c.Topics().Stat("updater"), true, payload)
+}
+
+func (c *Client) PublishBroker(broker string) error {
+	topic := c.Topics().Stat("broker")
+	return c.publish(topic, true, []byte(broker))
+}
+
+func (c *Client) PublishReconnects(count int) error {
+	topic := c.Topics().Stat("reconnects")
+	return c.publish(topic, true, []byte(strconv.Itoa(count)))
 }
 
-func (c *Client) Subscribe(topic string, handler func(payload []byte)) error {
-	token := c.paho.Subscribe(topic, 1, func(_ pahomqtt.Client, msg pahomqtt.Message) {
-		handler(msg.Payload())
-	})
-	token.Wait()
-	return token.Error()
+func (c *Client) Reconnects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return max(c.connects-1, 0)
 }
 
 func (c *Client) Disconnect() {
-	if c.paho != nil && c.paho.IsConnected() {
-		c.paho.Disconnect(250)
+	c.cancel()
+	if c.conn != nil && c.conn.isConnected() {
+		c.drain()
+		c.conn.disconnect()
 	}
 }
 
-func (c *Client) buildOptions() *pahomqtt.ClientOptions {
-	broker := fmt.Sprintf("tcp://%s:%d", c.cfg.Broker, c.cfg.Port)
-	lwtTopic := fmt.Sprintf("stat/%s/status", c.cfg.ClientID)
+func (c *Client) buildOptions() (*pahomqtt.ClientOptions, error) {
+	brokers, err := c.cfg.BrokerURLs()
+	if err != nil {
+		return nil, err
+	}
+	c.servers = brokers
+
+	opts := pahomqtt.NewClientOptions()
+	for _, b := range brokers {
+		opts.AddBroker(b)
+	}
+	if !c.passive {
+		opts.SetWill(c.Topics().Stat("status"), "offline", 1, true)
+	}
 
-	return pahomqtt.NewClientOptions().
-		AddBroker(broker).
-		SetClientID(c.cfg.ClientID).
+	opts.SetClientID(c.clientID()).
 		SetUsername(c.cfg.Username).
 		SetPassword(c.cfg.Password).
-		SetWill(lwtTopic, "offline", 1, true).
 		SetAutoReconnect(true).
-		SetCleanSession(false).
+		SetCleanSession(c.passive).
+		SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+			c.mu.Lock()
+			c.attempting = broker.String()
+			c.mu.Unlock()
+			return tlsCfg
+		}).
 		SetOnConnectHandler(func(_ pahomqtt.Client) {
-			if c.onConnect != nil {
-				c.onConnect()
-			}
+			c.handleConnect()
 		})
+	return opts, nil
+}
+
+func (c *Client) handleConnect() {
+	c.outbox.signal()
+
+	c.mu.Lock()
+	c.current = c.attempting
+	c.connects++
+	broker := c.current
+	reconnects := c.connects - 1
+	c.mu.Unlock()
+
+	if broker != "" {
+		log.Printf("MQTT connected to %s", broker)
+	}
+	if broker != "" && !c.passive {
+		if err := c.PublishBroker(broker); err != nil {
+			log.Printf("failed to publish current broker: %v", err)
+		}
+	}
+	c.resubscribe()
+	if !c.passive {
+		if err := c.PublishReconnects(reconnects); err != nil {
+			log.Printf("failed to publish reconnect count: %v", err)
+		}
+	}
+	if c.onConnect != nil {
+		c.onConnect()
+	}
 }
@@ -1,7 +1,11 @@
 package mqtt
 
 import (
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -30,30 +34,79 @@ func (m *mockMessage) MessageID() uint16  { return 0 }
 func (m *mockMessage) Payload() []byte    { return m.payload }
 func (m *mockMessage) Ack()               {}
 
+type publishedMessage struct {
+	topic    string
+	payload  string
+	retained bool
+}
+
 type mockPahoClient struct {
+	mu               sync.Mutex
 	isConnected      bool
-	published        []struct{ topic, payload string }
+	connects         int
+	published        []publishedMessage
+	hang             bool
 	subscriptions    map[string]pahomqtt.MessageHandler
 	onConnectHandler pahomqtt.OnConnectHandler
 }
 
 func (m *mockPahoClient) Connect() pahomqtt.Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connects++
 	m.isConnected = true
 	return &mockToken{}
 }
-func (m *mockPahoClient) Disconnect(quiesce uint)  { m.isConnected = false }
-func (m *mockPahoClient) IsConnected() bool        { return m.isConnected }
-func (m *mockPahoClient) IsConnectionOpen() bool   { return m.isConnected }
+func (m *mockPahoClient) Disconnect(quiesce uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.isConnected = false
+}
+func (m *mockPahoClient) IsConnected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isConnected
+}
+func (m *mockPahoClient) IsConnectionOpen() bool { return m.IsConnected() }
 func (m *mockPahoClient) AddRoute(topic string, callback pahomqtt.MessageHandler) {}
 func (m *mockPahoClient) OptionsReader() pahomqtt.ClientOptionsReader {
 	return pahomqtt.ClientOptionsReader{}
 }
 
 func (m *mockPahoClient) Publish(topic string, qos byte, retained bool, payload interface{}) pahomqtt.Token {
-	m.published = append(m.published, struct{ topic, payload string }{topic, fmt.Sprint(payload)})
+	text := fmt.Sprint(payload)
+	if b, ok := payload.([]byte); ok {
+		text = string(b)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hang {
+		return &hangingToken{}
+	}
+	m.published = append(m.published, publishedMessage{topic, text, retained})
 	return &mockToken{}
 }
+
+func (m *mockPahoClient) messages() []publishedMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]publishedMessage(nil), m.published...)
+}
+
+func waitPublished(t *testing.T, m *mockPahoClient, n int) []publishedMessage {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if msgs := m.messages(); len(msgs) >= n {
+			return msgs
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return m.messages()
+}
 func (m *mockPahoClient) Subscribe(topic string, qos byte, callback pahomqtt.MessageHandler) pahomqtt.Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.subscriptions == nil {
 		m.subscriptions = make(map[string]pahomqtt.MessageHandler)
 	}
@@ -104,16 +157,17 @@ func TestPublishStatus(t *testing.T) {
 		t.Fatalf("PublishStatus() error = %v", err)
 	}
 
-	if len(mock.published) != 1 {
-		t.Fatalf("expected 1 published message, got %d", len(mock.published))
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(published))
 	}
 
 	expectedTopic := "stat/test-pc/status"
-	if mock.published[0].topic != expectedTopic {
-		t.Errorf("topic = %q, want %q", mock.published[0].topic, expectedTopic)
+	if published[0].topic != expectedTopic {
+		t.Errorf("topic = %q, want %q", published[0].topic, expectedTopic)
 	}
-	if mock.published[0].payload != "online" {
-		t.Errorf("payload = %q, want %q", mock.published[0].payload, "online")
+	if published[0].payload != "online" {
+		t.Errorf("payload = %q, want %q", published[0].payload, "online")
 	}
 }
 
@@ -148,14 +202,15 @@ func TestPublish(t *testing.T) {
 		t.Fatalf("Publish() error = %v", err)
 	}
 
-	if len(mock.published) != 1 {
-		t.Fatalf("expected 1 published message, got %d", len(mock.published))
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(published))
 	}
-	if mock.published[0].topic != "stat/test-pc/current_mode" {
-		t.Errorf("topic = %q, want %q", mock.published[0].topic, "stat/test-pc/current_mode")
+	if published[0].topic != "stat/test-pc/current_mode" {
+		t.Errorf("topic = %q, want %q", published[0].topic, "stat/test-pc/current_mode")
 	}
-	if mock.published[0].payload != "BLOCKED" {
-		t.Errorf("payload = %q, want %q", mock.published[0].payload, "BLOCKED")
+	if published[0].payload != "BLOCKED" {
+		t.Errorf("payload = %q, want %q", published[0].payload, "BLOCKED")
 	}
 }
 
@@ -201,16 +256,35 @@ func TestPublishDiscovery(t *testing.T) {
 		"homeassistant/select/test-pc/mode/config",
 		"homeassistant/binary_sensor/test-pc/connectivity/config",
 		"homeassistant/sensor/test-pc/apps/config",
+		"homeassistant/sensor/test-pc/running_blacklisted/config",
+		"homeassistant/text/test-pc/blacklist_add/config",
+		"homeassistant/text/test-pc/blacklist_remove/config",
+		"homeassistant/number/test-pc/daily_minutes/config",
+		"homeassistant/number/test-pc/warning_minutes/config",
+		"homeassistant/sensor/test-pc/used_minutes/config",
+		"homeassistant/button/test-pc/rescan/config",
+		"homeassistant/button/test-pc/republish/config",
+		"homeassistant/button/test-pc/check_update/config",
+		"homeassistant/update/test-pc/agent/config",
 		"homeassistant/sensor/test-pc/version/config",
+		"homeassistant/sensor/test-pc/broker/config",
+		"homeassistant/sensor/test-pc/reconnects/config",
+		"homeassistant/sensor/test-pc/outbox/config",
+		"homeassistant/sensor/test-pc/updater_result/config",
+		"homeassistant/sensor/test-pc/updater_checked_at/config",
+		"homeassistant/sensor/test-pc/updater_latest_version/config",
+		"homeassistant/sensor/test-pc/updater_progress/config",
+		"homeassistant/sensor/test-pc/updater_error/config",
 	}
 
-	if len(mock.published) != len(expectedTopics) {
-		t.Fatalf("expected %d published messages, got %d", len(expectedTopics), len(mock.published))
+	published := waitPublished(t, mock, len(expectedTopics))
+	if len(published) != len(expectedTopics) {
+		t.Fatalf("expected %d published messages, got %d", len(expectedTopics), len(published))
 	}
 
 	for i, topic := range expectedTopics {
-		if mock.published[i].topic != topic {
-			t.Errorf("published[%d].topic = %q, want %q", i, mock.published[i].topic, topic)
+		if published[i].topic != topic {
+			t.Errorf("published[%d].topic = %q, want %q", i, published[i].topic, topic)
 		}
 	}
 }
@@ -223,14 +297,15 @@ func TestPublishVersion(t *testing.T) {
 		t.Fatalf("PublishVersion() error = %v", err)
 	}
 
-	if len(mock.published) != 1 {
-		t.Fatalf("expected 1 published message, got %d", len(mock.published))
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(published))
 	}
-	if mock.published[0].topic != "stat/test-pc/version" {
-		t.Errorf("topic = %q, want %q", mock.published[0].topic, "stat/test-pc/version")
+	if published[0].topic != "stat/test-pc/version" {
+		t.Errorf("topic = %q, want %q", published[0].topic, "stat/test-pc/version")
 	}
-	if mock.published[0].payload != "v1.2.3" {
-		t.Errorf("payload = %q, want %q", mock.published[0].payload, "v1.2.3")
+	if published[0].payload != "v1.2.3" {
+		t.Errorf("payload = %q, want %q", published[0].payload, "v1.2.3")
 	}
 }
 
@@ -252,3 +327,358 @@ func TestExponentialDelay(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildOptionsBrokerURL(t *testing.T) {
+	cases := []struct {
+		broker string
+		port   int
+		want   string
+	}{
+		{"localhost", 1883, "tcp://localhost:1883"},
+		{"wss://example.org/mqtt", 0, "wss://example.org:443/mqtt"},
+		{"ws://broker.lan:9001", 1883, "ws://broker.lan:9001"},
+	}
+
+	for _, tc := range cases {
+		cfg := testConfig()
+		cfg.Broker = tc.broker
+		cfg.Port = tc.port
+
+		opts, err := newClientWithFactory(cfg, nil).buildOptions()
+		if err != nil {
+			t.Fatalf("buildOptions(%q) error = %v", tc.broker, err)
+		}
+		if len(opts.Servers) != 1 || opts.Servers[0].String() != tc.want {
+			t.Errorf("buildOptions(%q) servers = %v, want [%s]", tc.broker, opts.Servers, tc.want)
+		}
+	}
+}
+
+func TestConnectInvalidBroker(t *testing.T) {
+	cfg := testConfig()
+	cfg.Broker = "http://broker.lan"
+	client, _ := newTestClient(cfg)
+
+	if err := client.Connect(); err == nil {
+		t.Error("expected error for unsupported broker scheme, got nil")
+	}
+}
+
+func TestBuildOptionsBrokerList(t *testing.T) {
+	cfg := testConfig()
+	cfg.Brokers = []string{"primary.lan", "ws://backup.lan:9001"}
+
+	opts, err := newClientWithFactory(cfg, nil).buildOptions()
+	if err != nil {
+		t.Fatalf("buildOptions() error = %v", err)
+	}
+
+	want := []string{"tcp://primary.lan:1883", "ws://backup.lan:9001"}
+	if len(opts.Servers) != len(want) {
+		t.Fatalf("servers = %v, want %v", opts.Servers, want)
+	}
+	for i := range want {
+		if opts.Servers[i].String() != want[i] {
+			t.Errorf("servers[%d] = %q, want %q", i, opts.Servers[i], want[i])
+		}
+	}
+}
+
+func TestOnConnectPublishesCurrentBroker(t *testing.T) {
+	cfg := testConfig()
+	cfg.Brokers = []string{"primary.lan", "backup.lan"}
+	client, mock := newTestClient(cfg)
+	_ = client.Connect()
+
+	client.attempting = "tcp://backup.lan:1883"
+	mock.onConnectHandler(mock)
+
+	if got := client.CurrentBroker(); got != "tcp://backup.lan:1883" {
+		t.Errorf("CurrentBroker() = %q, want %q", got, "tcp://backup.lan:1883")
+	}
+	published := waitPublished(t, mock, 2)
+	if len(published) != 2 || published[0].topic != "stat/test-pc/broker" {
+		t.Fatalf("published = %v, want current broker then reconnect count", published)
+	}
+	if published[0].payload != "tcp://backup.lan:1883" {
+		t.Errorf("payload = %q, want %q", published[0].payload, "tcp://backup.lan:1883")
+	}
+}
+
+func TestCheckPrimaryFailsBack(t *testing.T) {
+	cfg := testConfig()
+	cfg.Brokers = []string{"primary.lan", "backup.lan"}
+	client, mock := newTestClient(cfg)
+	defer client.Disconnect()
+	_ = client.Connect()
+
+	var probed string
+	client.probe = func(addr string) error {
+		probed = addr
+		return nil
+	}
+
+	client.current = "tcp://primary.lan:1883"
+	client.checkPrimary()
+	if mock.connects != 1 {
+		t.Fatalf("connects = %d, want 1 while on primary", mock.connects)
+	}
+
+	client.current = "tcp://backup.lan:1883"
+	client.checkPrimary()
+	if probed != "primary.lan:1883" {
+		t.Errorf("probed = %q, want %q", probed, "primary.lan:1883")
+	}
+	if mock.connects != 2 {
+		t.Errorf("connects = %d, want 2 after failback", mock.connects)
+	}
+}
+
+func TestCheckPrimaryBacksOffAfterFailedFailback(t *testing.T) {
+	cfg := testConfig()
+	cfg.Brokers = []string{"primary.lan", "backup.lan"}
+	client, mock := newTestClient(cfg)
+	defer client.Disconnect()
+	_ = client.Connect()
+	client.probe = func(string) error { return nil }
+
+	client.current = "tcp://backup.lan:1883"
+	client.checkPrimary()
+	if mock.connects != 2 {
+		t.Fatalf("connects = %d, want 2 after the failback attempt", mock.connects)
+	}
+	if client.failback != primaryCheckInterval {
+		t.Errorf("failback = %s, want %s", client.failback, primaryCheckInterval)
+	}
+
+	client.checkPrimary()
+	if mock.connects != 2 {
+		t.Errorf("connects = %d, want no new attempt while backing off", mock.connects)
+	}
+
+	client.nextProbe = time.Time{}
+	client.checkPrimary()
+	if mock.connects != 3 || client.failback != 2*primaryCheckInterval {
+		t.Errorf("connects = %d, failback = %s, want 3 and %s", mock.connects, client.failback, 2*primaryCheckInterval)
+	}
+}
+
+func TestCheckPrimaryStaysWhenUnreachable(t *testing.T) {
+	cfg := testConfig()
+	cfg.Brokers = []string{"primary.lan", "backup.lan"}
+	client, mock := newTestClient(cfg)
+	defer client.Disconnect()
+	_ = client.Connect()
+
+	client.probe = func(string) error { return fmt.Errorf("connection refused") }
+	client.current = "tcp://backup.lan:1883"
+	client.checkPrimary()
+
+	if mock.connects != 1 {
+		t.Errorf("connects = %d, want 1 when primary is unreachable", mock.connects)
+	}
+	if !mock.isConnected {
+		t.Error("expected client to stay connected to the backup broker")
+	}
+}
+
+func TestPublishDiscoveryCustomTopics(t *testing.T) {
+	cfg := testConfig()
+	cfg.Topics = config.TopicConfig{Prefix: "maison", DiscoveryPrefix: "ha"}
+	client, mock := newTestClient(cfg)
+	_ = client.Connect()
+
+	if err := client.PublishDiscovery(); err != nil {
+		t.Fatalf("PublishDiscovery() error = %v", err)
+	}
+
+	published := waitPublished(t, mock, 1)
+	if published[0].topic != "ha/select/test-pc/mode/config" {
+		t.Errorf("topic = %q, want %q", published[0].topic, "ha/select/test-pc/mode/config")
+	}
+	if !strings.Contains(published[0].payload, `"command_topic":"maison/cmnd/test-pc/mode"`) {
+		t.Errorf("payload = %s, want command_topic on custom layout", published[0].payload)
+	}
+}
+
+func TestSubscriptionsReappliedOnReconnect(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+
+	if err := client.Subscribe("cmnd/test-pc/mode", func([]byte) {}); err != nil {
+		t.Fatalf("Subscribe() before Connect() error = %v", err)
+	}
+	_ = client.Connect()
+	mock.onConnectHandler(mock)
+
+	if _, ok := mock.subscriptions["cmnd/test-pc/mode"]; !ok {
+		t.Fatal("expected registered subscription to be applied on connect")
+	}
+
+	mock.mu.Lock()
+	mock.subscriptions = nil
+	mock.mu.Unlock()
+	mock.onConnectHandler(mock)
+
+	if _, ok := mock.subscriptions["cmnd/test-pc/mode"]; !ok {
+		t.Error("expected subscription to be re-applied after reconnect")
+	}
+	if got := client.Reconnects(); got != 1 {
+		t.Errorf("Reconnects() = %d, want 1", got)
+	}
+
+	published := waitPublished(t, mock, 2)
+	if last := published[len(published)-1]; last.topic != "stat/test-pc/reconnects" || last.payload != "1" {
+		t.Errorf("last published = %+v, want reconnect count 1", last)
+	}
+}
+
+func TestUnsubscribeRemovesFromRegistry(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	_ = client.Connect()
+
+	_ = client.Subscribe("stat/test-pc/current_mode", func([]byte) {})
+	if err := client.Unsubscribe("stat/test-pc/current_mode"); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+
+	mock.mu.Lock()
+	mock.subscriptions = nil
+	mock.mu.Unlock()
+	mock.onConnectHandler(mock)
+
+	if _, ok := mock.subscriptions["stat/test-pc/current_mode"]; ok {
+		t.Error("expected unsubscribed topic not to be re-applied")
+	}
+}
+
+func TestPublishDiscoveryEntityMetadata(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	client.SetVersion("v1.2.3")
+	_ = client.Connect()
+
+	if err := client.PublishDiscovery(); err != nil {
+		t.Fatalf("PublishDiscovery() error = %v", err)
+	}
+	published := waitPublished(t, mock, 22)
+
+	payloads := make(map[string]map[string]any)
+	for _, m := range published {
+		var p map[string]any
+		if err := json.Unmarshal([]byte(m.payload), &p); err != nil {
+			t.Fatalf("invalid discovery payload on %s: %v", m.topic, err)
+		}
+		payloads[m.topic] = p
+		device, _ := p["device"].(map[string]any)
+		if device["sw_version"] != "v1.2.3" || device["name"] != "test-pc" {
+			t.Errorf("%s device = %v, want full device block with version", m.topic, device)
+		}
+	}
+
+	mode := payloads["homeassistant/select/test-pc/mode/config"]
+	if mode["availability_topic"] != "stat/test-pc/status" || mode["object_id"] != "test-pc_mode" || mode["icon"] == nil {
+		t.Errorf("mode payload = %v, want availability, object_id and icon", mode)
+	}
+	version := payloads["homeassistant/sensor/test-pc/version/config"]
+	if version["entity_category"] != "diagnostic" {
+		t.Errorf("version entity_category = %v, want diagnostic", version["entity_category"])
+	}
+	daily := payloads["homeassistant/number/test-pc/daily_minutes/config"]
+	if daily["command_topic"] != "cmnd/test-pc/quota/daily_minutes" || daily["state_topic"] != "stat/test-pc/quota" {
+		t.Errorf("daily_minutes payload = %v, want quota command and state topics", daily)
+	}
+	rescan := payloads["homeassistant/button/test-pc/rescan/config"]
+	if rescan["command_topic"] != "cmnd/test-pc/rescan" || rescan["payload_press"] != "PRESS" {
+		t.Errorf("rescan payload = %v, want rescan command", rescan)
+	}
+	agentUpdate := payloads["homeassistant/update/test-pc/agent/config"]
+	if agentUpdate["state_topic"] != "stat/test-pc/update" || agentUpdate["command_topic"] != "cmnd/test-pc/update/install" || agentUpdate["payload_install"] != "install" {
+		t.Errorf("update payload = %v, want update state and install command", agentUpdate)
+	}
+	connectivity := payloads["homeassistant/binary_sensor/test-pc/connectivity/config"]
+	if connectivity["entity_category"] != "diagnostic" || connectivity["availability_topic"] != nil {
+		t.Errorf("connectivity payload = %v, want diagnostic without availability", connectivity)
+	}
+	checkedAt := payloads["homeassistant/sensor/test-pc/updater_checked_at/config"]
+	if checkedAt["state_topic"] != "stat/test-pc/updater" || checkedAt["device_class"] != "timestamp" ||
+		checkedAt["entity_category"] != "diagnostic" || checkedAt["availability_topic"] != nil {
+		t.Errorf("updater_checked_at payload = %v, want diagnostic timestamp on stat/updater without availability", checkedAt)
+	}
+}
+
+func TestPublishOutbox(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	if err := client.PublishOutbox(PublishStats{Queued: 3, Failed: 1, LastError: "timeout"}); err != nil {
+		t.Fatalf("PublishOutbox() error = %v", err)
+	}
+
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 || published[0].topic != "stat/test-pc/outbox" || !published[0].retained {
+		t.Fatalf("published = %v, want retained stats on stat/test-pc/outbox", published)
+	}
+	var stats PublishStats
+	if err := json.Unmarshal([]byte(published[0].payload), &stats); err != nil || stats.Queued != 3 || stats.LastError != "timeout" {
+		t.Errorf("payload = %s, want the publish stats", published[0].payload)
+	}
+}
+
+func TestPublishUpdater(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	if err := client.PublishUpdater(map[string]any{"result": "up_to_date"}); err != nil {
+		t.Fatalf("PublishUpdater() error = %v", err)
+	}
+
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(published))
+	}
+	if published[0].topic != "stat/test-pc/updater" || !published[0].retained || published[0].payload != `{"result":"up_to_date"}` {
+		t.Errorf("published = %+v, want retained state on stat/test-pc/updater", published[0])
+	}
+}
+
+func TestPublishRunningAppsCountAndAttributes(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	apps := []map[string]any{{"pid": 1, "name": "game.exe"}, {"pid": 2, "name": "chrome.exe"}}
+	if err := client.PublishRunningApps(apps); err != nil {
+		t.Fatalf("PublishRunningApps() error = %v", err)
+	}
+
+	published := waitPublished(t, mock, 2)
+	if len(published) != 2 {
+		t.Fatalf("expected 2 published messages, got %d", len(published))
+	}
+	if published[0].topic != "stat/test-pc/running_apps" || published[0].payload != "2" {
+		t.Errorf("state = %+v, want count 2 on running_apps", published[0])
+	}
+	if published[1].topic != "stat/test-pc/running_apps/attributes" || !strings.Contains(published[1].payload, `"apps":[{`) {
+		t.Errorf("attributes = %+v, want apps list object", published[1])
+	}
+}
+
+func TestPublishRunningBlacklisted(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	_ = client.PublishRunningBlacklisted(nil)
+	waitPublished(t, mock, 2)
+	_ = client.PublishRunningBlacklisted([]string{"roblox.exe"})
+
+	published := waitPublished(t, mock, 4)
+	want := []publishedMessage{
+		{"stat/test-pc/running_blacklisted", "0", true},
+		{"stat/test-pc/running_blacklisted/attributes", `{"apps":[]}`, true},
+		{"stat/test-pc/running_blacklisted", "1", true},
+		{"stat/test-pc/running_blacklisted/attributes", `{"apps":["roblox.exe"]}`, true},
+	}
+	if !slices.Equal(published, want) {
+		t.Errorf("published = %v, want %v", published, want)
+	}
+}
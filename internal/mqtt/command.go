@@ -0,0 +1,193 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+
+	resultExpiry = time.Hour
+)
+
+type Command struct {
+	Name        string
+	ID          string
+	ReplyTo     string
+	Payload     []byte
+	Sent        time.Time
+	Correlation []byte
+	Properties  map[string]string
+}
+
+type Result struct {
+	ID      string `json:"id,omitempty"`
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	State   any    `json:"state,omitempty"`
+}
+
+type CommandHandler func(cmd Command) Result
+
+func Ack(message string, state any) Result {
+	return Result{Status: StatusOK, Message: message, State: state}
+}
+
+func Nack(err error, state any) Result {
+	return Result{Status: StatusError, Message: err.Error(), State: state}
+}
+
+type Envelope struct {
+	ID        string          `json:"id,omitempty"`
+	ReplyTo   string          `json:"reply_to,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp int64           `json:"ts,omitempty"`
+	Nonce     string          `json:"nonce,omitempty"`
+	Signature string          `json:"sig,omitempty"`
+}
+
+func parseCommand(name string, raw []byte) (Command, *Envelope) {
+	cmd := Command{Name: name, Payload: raw}
+
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return cmd, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &fields); err != nil {
+		return cmd, nil
+	}
+	_, hasPayload := fields["payload"]
+	_, hasID := fields["id"]
+	_, hasReplyTo := fields["reply_to"]
+	_, hasSignature := fields["sig"]
+	if !hasPayload || (!hasID && !hasReplyTo && !hasSignature) {
+		return cmd, nil
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(trimmed, &env); err != nil {
+		return cmd, nil
+	}
+
+	cmd.ID = env.ID
+	cmd.ReplyTo = env.ReplyTo
+	cmd.Payload = env.Payload
+
+	var text string
+	if err := json.Unmarshal(env.Payload, &text); err == nil {
+		cmd.Payload = []byte(text)
+	}
+	return cmd, &env
+}
+
+func parseMessage(name string, msg inboundMessage) (Command, *Envelope) {
+	cmd, env := parseCommand(name, msg.Payload)
+
+	props := msg.UserProperties
+	if env == nil && (props["sig"] != "" || props["id"] != "" || props["ts"] != "") {
+		ts, _ := strconv.ParseInt(props["ts"], 10, 64)
+		env = &Envelope{
+			ID:        props["id"],
+			ReplyTo:   msg.ResponseTopic,
+			Payload:   msg.Payload,
+			Timestamp: ts,
+			Nonce:     props["nonce"],
+			Signature: props["sig"],
+		}
+		cmd.ID = env.ID
+	}
+
+	if cmd.ReplyTo == "" {
+		cmd.ReplyTo = msg.ResponseTopic
+	}
+	if env != nil && env.Timestamp > 0 {
+		cmd.Sent = time.Unix(env.Timestamp, 0)
+	}
+	cmd.Correlation = msg.Correlation
+	cmd.Properties = props
+	return cmd, env
+}
+
+func (c *Client) SubscribeCommand(name string, handler CommandHandler) error {
+	return c.subscribeCommand(name, handler, false)
+}
+
+func (c *Client) SubscribeCommandAsync(name string, handler CommandHandler) error {
+	return c.subscribeCommand(name, handler, true)
+}
+
+func (c *Client) subscribeCommand(name string, handler CommandHandler, async bool) error {
+	topic := c.Topics().Cmnd(name)
+	return c.subscribeMessage(topic, func(msg inboundMessage) {
+		cmd, env := parseMessage(name, msg)
+
+		if c.verifier != nil {
+			if err := c.verifier.verify(topic, env); err != nil {
+				log.Printf("cmnd: rejected %s: %v", name, err)
+				c.reportTamper(topic, err)
+				cmd.ReplyTo = ""
+				if err := c.publishResult(cmd, Nack(err, nil)); err != nil {
+					log.Printf("failed to publish %s result: %v", name, err)
+				}
+				return
+			}
+		}
+
+		run := func() {
+			result := handler(cmd)
+			if err := c.publishResult(cmd, result); err != nil {
+				log.Printf("failed to publish %s result: %v", name, err)
+			}
+		}
+		if async {
+			go run()
+			return
+		}
+		run()
+	})
+}
+
+func (c *Client) publishResult(cmd Command, result Result) error {
+	result.ID = cmd.ID
+	result.Command = cmd.Name
+
+	topic := cmd.ReplyTo
+	if topic != "" && !validReplyTopic(topic) {
+		log.Printf("cmnd: ignoring invalid reply_to %q for %s", topic, cmd.Name)
+		topic = ""
+	}
+	if topic == "" {
+		topic = c.Topics().Stat("result")
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	props := map[string]string{"command": result.Command, "status": result.Status}
+	if result.ID != "" {
+		props["id"] = result.ID
+	}
+	return c.publishEntry(outboxEntry{
+		Topic:       topic,
+		Payload:     data,
+		Expires:     time.Now().Add(resultExpiry),
+		Correlation: cmd.Correlation,
+		Properties:  props,
+	})
+}
+
+func validReplyTopic(topic string) bool {
+	return validTopicName(topic) && !slices.Contains(strings.Split(topic, "/"), "")
+}
@@ -0,0 +1,208 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCommandPlainPayload(t *testing.T) {
+	cases := []string{
+		"BLOCKED",
+		`["game.exe"]`,
+		`{"title":"Dîner","message":"À table !"}`,
+		`{"payload":"ACTIVE"}`,
+	}
+
+	for _, raw := range cases {
+		cmd, _ := parseCommand("mode", []byte(raw))
+		if string(cmd.Payload) != raw {
+			t.Errorf("parseCommand(%q).Payload = %q, want unchanged", raw, cmd.Payload)
+		}
+		if cmd.ID != "" || cmd.ReplyTo != "" {
+			t.Errorf("parseCommand(%q) = %+v, want no envelope", raw, cmd)
+		}
+	}
+}
+
+func TestParseCommandEnvelope(t *testing.T) {
+	cmd, _ := parseCommand("blacklist/set", []byte(`{"id":"42","reply_to":"ha/replies","payload":["game.exe"]}`))
+
+	if cmd.ID != "42" {
+		t.Errorf("ID = %q, want %q", cmd.ID, "42")
+	}
+	if cmd.ReplyTo != "ha/replies" {
+		t.Errorf("ReplyTo = %q, want %q", cmd.ReplyTo, "ha/replies")
+	}
+	if string(cmd.Payload) != `["game.exe"]` {
+		t.Errorf("Payload = %q, want %q", cmd.Payload, `["game.exe"]`)
+	}
+
+	cmd, _ = parseCommand("mode", []byte(`{"id":"43","payload":"BLOCKED"}`))
+	if string(cmd.Payload) != "BLOCKED" {
+		t.Errorf("Payload = %q, want %q", cmd.Payload, "BLOCKED")
+	}
+}
+
+func TestSubscribeCommandPublishesResult(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	err := client.SubscribeCommand("blacklist/set", func(cmd Command) Result {
+		return Nack(errors.New("invalid blacklist payload"), []string{"game.exe"})
+	})
+	if err != nil {
+		t.Fatalf("SubscribeCommand() error = %v", err)
+	}
+
+	handler := mock.subscriptions["cmnd/test-pc/blacklist/set"]
+	if handler == nil {
+		t.Fatal("expected subscription on cmnd/test-pc/blacklist/set")
+	}
+	handler(mock, &mockMessage{topic: "cmnd/test-pc/blacklist/set", payload: []byte("not json")})
+
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(published))
+	}
+	if published[0].topic != "stat/test-pc/result" {
+		t.Errorf("topic = %q, want %q", published[0].topic, "stat/test-pc/result")
+	}
+
+	var result Result
+	if err := json.Unmarshal([]byte(published[0].payload), &result); err != nil {
+		t.Fatalf("invalid result payload: %v", err)
+	}
+	if result.Status != StatusError || result.Command != "blacklist/set" {
+		t.Errorf("result = %+v, want error for blacklist/set", result)
+	}
+	if result.Message != "invalid blacklist payload" {
+		t.Errorf("message = %q, want %q", result.Message, "invalid blacklist payload")
+	}
+}
+
+func TestSubscribeCommandRepliesToEnvelope(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	var received string
+	_ = client.SubscribeCommand("mode", func(cmd Command) Result {
+		received = string(cmd.Payload)
+		return Ack("mode updated", "BLOCKED")
+	})
+
+	mock.subscriptions["cmnd/test-pc/mode"](mock, &mockMessage{
+		topic:   "cmnd/test-pc/mode",
+		payload: []byte(`{"id":"abc","reply_to":"ha/replies/abc","payload":"BLOCKED"}`),
+	})
+
+	if received != "BLOCKED" {
+		t.Errorf("received = %q, want %q", received, "BLOCKED")
+	}
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 || published[0].topic != "ha/replies/abc" {
+		t.Fatalf("published = %v, want one message on ha/replies/abc", published)
+	}
+
+	var result Result
+	if err := json.Unmarshal([]byte(published[0].payload), &result); err != nil {
+		t.Fatalf("invalid result payload: %v", err)
+	}
+	if result.ID != "abc" || result.Status != StatusOK || result.State != "BLOCKED" {
+		t.Errorf("result = %+v, want ok with id abc and state BLOCKED", result)
+	}
+}
+
+func TestSubscribeCommandAsyncDoesNotBlockCallback(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	release := make(chan struct{})
+	_ = client.SubscribeCommandAsync("notify", func(cmd Command) Result {
+		<-release
+		return Ack("notification sent", nil)
+	})
+
+	returned := make(chan struct{})
+	go func() {
+		mock.subscriptions["cmnd/test-pc/notify"](mock, &mockMessage{topic: "cmnd/test-pc/notify", payload: []byte("Dîner")})
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("callback blocked on the handler")
+	}
+
+	close(release)
+	published := waitPublished(t, mock, 1)
+	if len(published) != 1 || published[0].topic != "stat/test-pc/result" {
+		t.Fatalf("published = %v, want the result once the handler returns", published)
+	}
+}
+
+func TestParseMessageUsesV5Properties(t *testing.T) {
+	cmd, env := parseMessage("notify", inboundMessage{
+		Topic:         "cmnd/test-pc/notify",
+		Payload:       []byte("Dîner"),
+		ResponseTopic: "ha/replies",
+		Correlation:   []byte("corr-1"),
+		UserProperties: map[string]string{
+			"id": "42",
+			"ts": "1700000000",
+		},
+	})
+
+	if env == nil {
+		t.Fatal("parseMessage() envelope = nil, want one built from user properties")
+	}
+	if cmd.ID != "42" || cmd.ReplyTo != "ha/replies" || string(cmd.Correlation) != "corr-1" {
+		t.Errorf("cmd = %+v, want id, reply topic and correlation from properties", cmd)
+	}
+	if cmd.Sent.Unix() != 1700000000 {
+		t.Errorf("Sent = %v, want unix 1700000000", cmd.Sent)
+	}
+	if string(cmd.Payload) != "Dîner" {
+		t.Errorf("Payload = %q, want %q", cmd.Payload, "Dîner")
+	}
+}
+
+func TestPublishResultCarriesCorrelation(t *testing.T) {
+	client, _ := newTestClient(testConfig())
+
+	cmd := Command{Name: "mode", ID: "7", ReplyTo: "ha/replies", Correlation: []byte("corr-7")}
+	if err := client.publishResult(cmd, Ack("ok", nil)); err != nil {
+		t.Fatalf("publishResult() error = %v", err)
+	}
+
+	e, ok := client.outbox.peek()
+	if !ok {
+		t.Fatal("outbox is empty")
+	}
+	if e.Topic != "ha/replies" || string(e.Correlation) != "corr-7" {
+		t.Errorf("entry = %+v, want reply topic and correlation data", e)
+	}
+	if e.Properties["command"] != "mode" || e.Properties["status"] != StatusOK || e.Properties["id"] != "7" {
+		t.Errorf("properties = %v, want command metadata", e.Properties)
+	}
+	if e.Expires.IsZero() {
+		t.Error("Expires is zero, want result expiry")
+	}
+}
+
+func TestPublishResultRejectsInvalidReplyTo(t *testing.T) {
+	for _, replyTo := range []string{"x/#", "ha/+/replies", "ha//replies", "/ha/replies"} {
+		client, _ := newTestClient(testConfig())
+
+		cmd := Command{Name: "mode", ReplyTo: replyTo}
+		if err := client.publishResult(cmd, Ack("ok", nil)); err != nil {
+			t.Fatalf("publishResult() error = %v", err)
+		}
+
+		e, ok := client.outbox.peek()
+		if !ok || e.Topic != "stat/test-pc/result" {
+			t.Errorf("reply_to %q: entry = %+v, want the default result topic", replyTo, e)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package mqtt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"home-guard/internal/config"
+)
+
+var defaultEncryptedTopics = []string{"stat/running_apps/attributes", "stat/running_blacklisted/attributes", "cmnd/notify"}
+
+var ErrNotEncrypted = errors.New("payload is not encrypted")
+
+type sealedPayload struct {
+	KeyID      string `json:"kid"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ct"`
+}
+
+type payloadCipher struct {
+	keys    map[string]cipher.AEAD
+	keyID   string
+	filters []string
+	exclude string
+}
+
+func newPayloadCipher(cfg config.EncryptionConfig, topics Topics) (*payloadCipher, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+
+	pc := &payloadCipher{
+		keys:    make(map[string]cipher.AEAD, len(cfg.Keys)),
+		keyID:   cfg.KeyID,
+		exclude: topics.DiscoveryPrefix() + "/",
+	}
+
+	for id, encoded := range cfg.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		pc.keys[id] = aead
+	}
+
+	if pc.keyID == "" && len(pc.keys) == 1 {
+		for id := range pc.keys {
+			pc.keyID = id
+		}
+	}
+	if _, ok := pc.keys[pc.keyID]; !ok {
+		return nil, fmt.Errorf("encryption key_id %q not found in keys", pc.keyID)
+	}
+
+	policy := cfg.Topics
+	if len(policy) == 0 {
+		policy = defaultEncryptedTopics
+	}
+	for _, p := range policy {
+		kind, name, ok := strings.Cut(p, "/")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid encrypted topic %q, expected stat/<name> or cmnd/<name>", p)
+		}
+		switch kind {
+		case "stat":
+			pc.filters = append(pc.filters, topics.Stat(name))
+		case "cmnd":
+			pc.filters = append(pc.filters, topics.Cmnd(name))
+		default:
+			return nil, fmt.Errorf("invalid encrypted topic %q, expected stat/<name> or cmnd/<name>", p)
+		}
+	}
+
+	return pc, nil
+}
+
+func (pc *payloadCipher) applies(topic string) bool {
+	if pc == nil || strings.HasPrefix(topic, pc.exclude) {
+		return false
+	}
+	for _, f := range pc.filters {
+		if topicMatches(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pc *payloadCipher) seal(topic string, plaintext []byte) ([]byte, error) {
+	aead := pc.keys[pc.keyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sealedPayload{
+		KeyID:      pc.keyID,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(topic))),
+	})
+}
+
+func (pc *payloadCipher) open(topic string, payload []byte) ([]byte, error) {
+	var sealed sealedPayload
+	if err := json.Unmarshal(payload, &sealed); err != nil || sealed.Ciphertext == "" {
+		return nil, ErrNotEncrypted
+	}
+
+	aead, ok := pc.keys[sealed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", sealed.KeyID)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(topic))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func topicMatches(filter, topic string) bool {
+	fparts := strings.Split(filter, "/")
+	tparts := strings.Split(topic, "/")
+
+	for i, f := range fparts {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tparts) {
+			return false
+		}
+		if f != "+" && f != tparts[i] {
+			return false
+		}
+	}
+	return len(fparts) == len(tparts)
+}
@@ -0,0 +1,173 @@
+package mqtt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"home-guard/internal/config"
+)
+
+var (
+	testKey1 = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testKey2 = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func testCipher(t *testing.T, cfg config.EncryptionConfig) *payloadCipher {
+	t.Helper()
+	pc, err := newPayloadCipher(cfg, NewTopics(config.TopicConfig{}, "test-pc"))
+	if err != nil {
+		t.Fatalf("newPayloadCipher() error = %v", err)
+	}
+	return pc
+}
+
+func TestPayloadCipherRoundTrip(t *testing.T) {
+	pc := testCipher(t, config.EncryptionConfig{Keys: map[string]string{"k1": testKey1}})
+
+	sealed, err := pc.seal("stat/test-pc/running_apps", []byte(`[{"pid":1}]`))
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if strings.Contains(string(sealed), "pid") {
+		t.Errorf("sealed payload leaks plaintext: %s", sealed)
+	}
+
+	plaintext, err := pc.open("stat/test-pc/running_apps", sealed)
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if string(plaintext) != `[{"pid":1}]` {
+		t.Errorf("plaintext = %s, want %s", plaintext, `[{"pid":1}]`)
+	}
+
+	if _, err := pc.open("stat/test-pc/current_mode", sealed); err == nil {
+		t.Error("expected open() to fail when the payload is replayed on another topic")
+	}
+	if _, err := pc.open("stat/test-pc/running_apps", []byte("[]")); err != ErrNotEncrypted {
+		t.Errorf("open(clear) error = %v, want %v", err, ErrNotEncrypted)
+	}
+}
+
+func TestPayloadCipherKeyRotation(t *testing.T) {
+	old := testCipher(t, config.EncryptionConfig{Keys: map[string]string{"k1": testKey1}})
+	sealed, err := old.seal("cmnd/test-pc/notify", []byte("hello"))
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+
+	rotated := testCipher(t, config.EncryptionConfig{
+		Keys:  map[string]string{"k1": testKey1, "k2": testKey2},
+		KeyID: "k2",
+	})
+	if plaintext, err := rotated.open("cmnd/test-pc/notify", sealed); err != nil || string(plaintext) != "hello" {
+		t.Errorf("open() with rotated keys = %q, %v, want %q", plaintext, err, "hello")
+	}
+
+	resealed, _ := rotated.seal("cmnd/test-pc/notify", []byte("hello"))
+	var env sealedPayload
+	_ = json.Unmarshal(resealed, &env)
+	if env.KeyID != "k2" {
+		t.Errorf("kid = %q, want %q", env.KeyID, "k2")
+	}
+}
+
+func TestPayloadCipherPolicy(t *testing.T) {
+	pc := testCipher(t, config.EncryptionConfig{
+		Keys:   map[string]string{"k1": testKey1},
+		Topics: []string{"stat/running_apps", "cmnd/#"},
+	})
+
+	cases := []struct {
+		topic string
+		want  bool
+	}{
+		{"stat/test-pc/running_apps", true},
+		{"stat/test-pc/current_mode", false},
+		{"cmnd/test-pc/blacklist/set", true},
+		{"homeassistant/sensor/test-pc/apps/config", false},
+	}
+	for _, tc := range cases {
+		if got := pc.applies(tc.topic); got != tc.want {
+			t.Errorf("applies(%q) = %v, want %v", tc.topic, got, tc.want)
+		}
+	}
+}
+
+func TestNewPayloadCipherErrors(t *testing.T) {
+	cases := []config.EncryptionConfig{
+		{Keys: map[string]string{"k1": "not base64!"}},
+		{Keys: map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))}},
+		{Keys: map[string]string{"k1": testKey1, "k2": testKey2}},
+		{Keys: map[string]string{"k1": testKey1}, Topics: []string{"tele/running_apps"}},
+	}
+	for i, cfg := range cases {
+		if _, err := newPayloadCipher(cfg, NewTopics(config.TopicConfig{}, "test-pc")); err == nil {
+			t.Errorf("case %d: expected error, got nil", i)
+		}
+	}
+}
+
+func TestClientEncryptsAccordingToPolicy(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encryption = config.EncryptionConfig{Keys: map[string]string{"k1": testKey1}}
+	client, mock := newTestClient(cfg)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	_ = client.PublishStatus("online")
+	_ = client.PublishRunningApps([]string{"game.exe"})
+
+	published := waitPublished(t, mock, 2)
+	if published[0].payload != "online" {
+		t.Errorf("status payload = %q, want clear %q", published[0].payload, "online")
+	}
+	if strings.Contains(published[1].payload, "game.exe") {
+		t.Errorf("running_apps payload not encrypted: %s", published[1].payload)
+	}
+
+	_ = client.PublishRunningBlacklisted([]string{"roblox.exe"})
+	published = waitPublished(t, mock, 5)
+	if published[3].payload != "1" {
+		t.Errorf("running_blacklisted payload = %q, want clear count", published[3].payload)
+	}
+	if strings.Contains(published[4].payload, "roblox.exe") {
+		t.Errorf("running_blacklisted attributes not encrypted: %s", published[4].payload)
+	}
+
+	var received string
+	_ = client.Subscribe("cmnd/test-pc/notify", func(payload []byte) {
+		received = string(payload)
+	})
+	handler := mock.subscriptions["cmnd/test-pc/notify"]
+
+	handler(mock, &mockMessage{topic: "cmnd/test-pc/notify", payload: []byte("clear text")})
+	if received != "" {
+		t.Errorf("received = %q, want clear payload to be dropped", received)
+	}
+
+	sealed, _ := client.cipher.seal("cmnd/test-pc/notify", []byte("À table !"))
+	handler(mock, &mockMessage{topic: "cmnd/test-pc/notify", payload: sealed})
+	if received != "À table !" {
+		t.Errorf("received = %q, want %q", received, "À table !")
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"stat/pc/#", "stat/pc/running_apps", true},
+		{"stat/+/status", "stat/pc/status", true},
+		{"stat/pc/status", "stat/pc/status/extra", false},
+		{"cmnd/pc/mode", "cmnd/pc", false},
+	}
+	for _, tc := range cases {
+		if got := topicMatches(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tc.filter, tc.topic, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultOutboxLimit = 500
+
+type outboxEntry struct {
+	Seq         uint64            `json:"seq"`
+	Topic       string            `json:"topic"`
+	Payload     []byte            `json:"payload"`
+	Retained    bool              `json:"retained"`
+	Expires     time.Time         `json:"expires,omitzero"`
+	Correlation []byte            `json:"correlation,omitempty"`
+	Properties  map[string]string `json:"properties,omitempty"`
+	Attempts    int               `json:"attempts,omitempty"`
+}
+
+type outbox struct {
+	mu      sync.Mutex
+	path    string
+	limit   int
+	seq     uint64
+	dropped uint64
+	entries []outboxEntry
+	ready   chan struct{}
+}
+
+func newOutbox(limit int) *outbox {
+	return &outbox{
+		limit: limit,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (o *outbox) load(path string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored []outboxEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	for _, e := range stored {
+		o.add(e)
+	}
+	for _, e := range o.entries {
+		o.seq = max(o.seq, e.Seq)
+	}
+	o.signal()
+	return o.save()
+}
+
+func (o *outbox) enqueue(e outboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	e.Seq = o.seq
+	dropped := o.dropped
+	o.add(e)
+	if !e.Retained || o.dropped != dropped {
+		o.persist()
+	}
+	o.signal()
+}
+
+func (o *outbox) add(e outboxEntry) {
+	if e.Retained {
+		o.entries = slices.DeleteFunc(o.entries, func(old outboxEntry) bool {
+			return old.Retained && old.Topic == e.Topic
+		})
+	}
+	o.entries = append(o.entries, e)
+
+	for len(o.entries) > o.limit {
+		o.dropOldest()
+	}
+}
+
+func (o *outbox) dropOldest() {
+	o.dropped++
+	for i, e := range o.entries {
+		if !e.Retained {
+			log.Printf("mqtt: outbox full, dropping message on %s", e.Topic)
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			return
+		}
+	}
+	log.Printf("mqtt: outbox full, dropping message on %s", o.entries[0].Topic)
+	o.entries = o.entries[1:]
+}
+
+func (o *outbox) peek() (outboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.entries) == 0 {
+		return outboxEntry{}, false
+	}
+	return o.entries[0], true
+}
+
+func (o *outbox) remove(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if e, ok := o.delete(seq); ok && !e.Retained {
+		o.persist()
+	}
+}
+
+func (o *outbox) drop(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e, ok := o.delete(seq)
+	if !ok {
+		return
+	}
+	o.dropped++
+	if !e.Retained {
+		o.persist()
+	}
+}
+
+func (o *outbox) attempt(seq uint64) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := slices.IndexFunc(o.entries, func(e outboxEntry) bool { return e.Seq == seq })
+	if i < 0 {
+		return 0
+	}
+	o.entries[i].Attempts++
+	if !o.entries[i].Retained {
+		o.persist()
+	}
+	return o.entries[i].Attempts
+}
+
+func (o *outbox) delete(seq uint64) (outboxEntry, bool) {
+	for i, e := range o.entries {
+		if e.Seq == seq {
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			return e, true
+		}
+	}
+	return outboxEntry{}, false
+}
+
+func (o *outbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+func (o *outbox) droppedCount() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.dropped
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) persist() {
+	if err := o.save(); err != nil {
+		log.Printf("mqtt: failed to persist outbox: %v", err)
+	}
+}
+
+func (o *outbox) save() error {
+	if o.path == "" {
+		return nil
+	}
+
+	stored := slices.DeleteFunc(slices.Clone(o.entries), func(e outboxEntry) bool { return e.Retained })
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
@@ -0,0 +1,155 @@
+package mqtt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func (o *outbox) push(topic string, payload []byte, retained bool) {
+	o.enqueue(outboxEntry{Topic: topic, Payload: payload, Retained: retained})
+}
+
+func outboxTopics(o *outbox) []string {
+	var topics []string
+	for _, e := range o.entries {
+		topics = append(topics, e.Topic+"="+string(e.Payload))
+	}
+	return topics
+}
+
+func assertTopics(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("entries = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entries[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestOutboxCoalescesRetainedTopics(t *testing.T) {
+	o := newOutbox(10)
+	o.push("stat/pc/current_mode", []byte("ACTIVE"), true)
+	o.push("stat/pc/result", []byte("1"), false)
+	o.push("stat/pc/current_mode", []byte("BLOCKED"), true)
+	o.push("stat/pc/result", []byte("2"), false)
+
+	assertTopics(t, outboxTopics(o), []string{
+		"stat/pc/result=1",
+		"stat/pc/current_mode=BLOCKED",
+		"stat/pc/result=2",
+	})
+}
+
+func TestOutboxDropsOldestEventWhenFull(t *testing.T) {
+	o := newOutbox(3)
+	o.push("stat/pc/status", []byte("online"), true)
+	o.push("stat/pc/result", []byte("1"), false)
+	o.push("stat/pc/result", []byte("2"), false)
+	o.push("stat/pc/tamper", []byte("3"), false)
+
+	assertTopics(t, outboxTopics(o), []string{
+		"stat/pc/status=online",
+		"stat/pc/result=2",
+		"stat/pc/tamper=3",
+	})
+}
+
+func TestOutboxPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+
+	o := newOutbox(10)
+	if err := o.load(path); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	o.push("stat/pc/current_mode", []byte("BLOCKED"), true)
+	o.push("stat/pc/result", []byte("1"), false)
+	first, _ := o.peek()
+	o.remove(first.Seq)
+
+	reloaded := newOutbox(10)
+	if err := reloaded.load(path); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	assertTopics(t, outboxTopics(reloaded), []string{"stat/pc/result=1"})
+
+	reloaded.push("stat/pc/result", []byte("2"), false)
+	if last := reloaded.entries[len(reloaded.entries)-1]; last.Seq <= first.Seq {
+		t.Errorf("seq = %d, want greater than %d after reload", last.Seq, first.Seq)
+	}
+}
+
+func TestOutboxOnlyPersistsEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+
+	o := newOutbox(10)
+	if err := o.load(path); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Time{}, before.ModTime().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	o.push("stat/pc/running_apps", []byte("3"), true)
+	first, _ := o.peek()
+	o.remove(first.Seq)
+	if after, _ := os.Stat(path); !after.ModTime().Before(before.ModTime()) {
+		t.Error("outbox.json rewritten for a retained state")
+	}
+
+	o.push("stat/pc/result", []byte("1"), false)
+	if after, _ := os.Stat(path); after.ModTime().Before(before.ModTime()) {
+		t.Error("outbox.json not rewritten for an event")
+	}
+}
+
+func TestClientQueuesWhileDisconnected(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	_ = client.Connect()
+	mock.Disconnect(0)
+
+	_ = client.Publish("stat/test-pc/current_mode", "ACTIVE")
+	_ = client.PublishStatus("online")
+	_ = client.Publish("stat/test-pc/current_mode", "BLOCKED")
+
+	time.Sleep(20 * time.Millisecond)
+	if got := len(mock.messages()); got != 0 {
+		t.Fatalf("published %d messages while disconnected, want 0", got)
+	}
+
+	mock.Connect()
+	mock.onConnectHandler(mock)
+
+	published := waitPublished(t, mock, 3)
+	if len(published) != 3 {
+		t.Fatalf("published = %v, want queued messages then reconnect count", published)
+	}
+	if published[0].topic != "stat/test-pc/status" || published[1].payload != "BLOCKED" {
+		t.Errorf("published = %v, want status then latest mode", published)
+	}
+	if client.outbox.len() != 0 {
+		t.Errorf("outbox len = %d, want 0 after flush", client.outbox.len())
+	}
+}
+
+func TestDisconnectFlushesOutbox(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+
+	_ = client.PublishStatus("offline")
+	client.Disconnect()
+
+	published := mock.messages()
+	if len(published) != 1 || published[0].payload != "offline" {
+		t.Errorf("published = %v, want offline status before disconnect", published)
+	}
+}
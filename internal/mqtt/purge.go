@@ -0,0 +1,56 @@
+package mqtt
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultPurgeTimeout = time.Second
+	purgeTimeoutMargin  = 5
+	purgePollInterval   = 50 * time.Millisecond
+)
+
+var retainedStats = []string{
+	"status",
+	"current_mode",
+	"running_apps",
+	"running_apps/attributes",
+	"running_blacklisted",
+	"running_blacklisted/attributes",
+	"broker",
+	"blacklist",
+	"reconnects",
+	"outbox",
+	"version",
+	"quota",
+	"update",
+	"updater",
+}
+
+func (c *Client) Purge(clientID string) error {
+	topics := c.Topics().WithClientID(clientID)
+	log.Printf("mqtt: clearing retained discovery and state for %s", clientID)
+
+	dropped := c.outbox.droppedCount()
+	c.clearDiscovery(topics)
+	for _, name := range retainedStats {
+		if err := c.publish(topics.Stat(name), true, nil); err != nil {
+			log.Printf("failed to clear %s: %v", topics.Stat(name), err)
+		}
+	}
+
+	timeout := time.Duration(c.outbox.len()+purgeTimeoutMargin) * c.purgeTimeout
+	deadline := time.Now().Add(timeout)
+	for c.outbox.len() > 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("purge of %s: %d message(s) still queued after %s", clientID, c.outbox.len(), timeout)
+		}
+		time.Sleep(purgePollInterval)
+	}
+	if n := c.outbox.droppedCount() - dropped; n > 0 {
+		return fmt.Errorf("purge of %s: %d message(s) dropped", clientID, n)
+	}
+	return nil
+}
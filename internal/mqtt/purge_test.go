@@ -0,0 +1,110 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"home-guard/internal/config"
+)
+
+func TestPassiveClientOptions(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	client.setPassive("purge")
+
+	opts, err := client.buildOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ClientID != "test-pc-purge" {
+		t.Errorf("ClientID = %q, want %q", opts.ClientID, "test-pc-purge")
+	}
+	if !opts.CleanSession {
+		t.Error("expected clean session")
+	}
+	if opts.WillEnabled {
+		t.Error("expected no last will")
+	}
+
+	_ = client.Connect()
+	mock.onConnectHandler(mock)
+	for _, m := range waitPublished(t, mock, 1) {
+		t.Errorf("unexpected publish on connect: %s", m.topic)
+	}
+	mock.mu.Lock()
+	_, subscribed := mock.subscriptions["homeassistant/status"]
+	mock.mu.Unlock()
+	if subscribed {
+		t.Error("passive client should not watch the HA birth topic")
+	}
+}
+
+func TestPurgeClearsEncryptedTopics(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encryption = config.EncryptionConfig{Keys: map[string]string{"k1": testKey1}}
+	client, mock := newTestClient(cfg)
+	defer client.Disconnect()
+	client.setPassive("purge")
+	_ = client.Connect()
+
+	if err := client.Purge("test-pc"); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+
+	entries := len(client.discoveryEntries(client.Topics()))
+	for _, m := range waitPublished(t, mock, entries+len(retainedStats)) {
+		if m.payload != "" {
+			t.Errorf("%s: payload = %q, want empty", m.topic, m.payload)
+		}
+	}
+}
+
+func TestPurgeClearsRetainedTopics(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	client.setPassive("purge")
+	_ = client.Connect()
+
+	if err := client.Purge("old-pc"); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+
+	entries := len(client.discoveryEntries(client.Topics()))
+	published := waitPublished(t, mock, entries+len(retainedStats))
+	if len(published) != entries+len(retainedStats) {
+		t.Fatalf("published %d messages, want %d", len(published), entries+len(retainedStats))
+	}
+	want := map[string]bool{
+		"homeassistant/select/old-pc/mode/config": false,
+		"stat/old-pc/status":                      false,
+		"stat/old-pc/current_mode":                false,
+	}
+	for _, m := range published {
+		if !m.retained || m.payload != "" {
+			t.Errorf("%s: retained=%v payload=%q, want empty retained", m.topic, m.retained, m.payload)
+		}
+		if _, ok := want[m.topic]; ok {
+			want[m.topic] = true
+		}
+	}
+	for topic, seen := range want {
+		if !seen {
+			t.Errorf("expected %s to be cleared", topic)
+		}
+	}
+}
+
+func TestPurgeReportsUnsentMessages(t *testing.T) {
+	client, _ := newTestClient(testConfig())
+	client.setPassive("purge")
+	_ = client.Connect()
+	client.Disconnect()
+
+	client.purgeTimeout = time.Millisecond
+	if err := client.Purge("old-pc"); err == nil {
+		t.Fatal("Purge() expected error while the broker is unreachable")
+	}
+	if n := client.outbox.len(); n == 0 {
+		t.Error("expected the clears to stay queued")
+	}
+}
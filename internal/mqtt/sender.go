@@ -0,0 +1,128 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPublishTimeout = 10 * time.Second
+	publishRetryDelay     = 5 * time.Second
+	drainTimeout          = 3 * time.Second
+	maxPublishAttempts    = 5
+)
+
+var errRejected = errors.New("message rejected")
+
+type PublishStats struct {
+	Queued    int    `json:"queued"`
+	Sent      uint64 `json:"sent"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (c *Client) SetOnPublishError(fn func(topic string, err error)) {
+	c.onPublishError = fn
+}
+
+func (c *Client) Stats() PublishStats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+
+	stats.Queued = c.outbox.len()
+	stats.Dropped = c.outbox.droppedCount()
+	return stats
+}
+
+func (c *Client) runSender() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.outbox.ready:
+		}
+
+		if err := c.flush(c.ctx); err != nil && c.ctx.Err() == nil {
+			time.AfterFunc(publishRetryDelay, c.outbox.signal)
+		}
+	}
+}
+
+func (c *Client) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := c.flush(ctx); err != nil {
+		log.Printf("mqtt: %d message(s) left in outbox: %v", c.outbox.len(), err)
+	}
+}
+
+func (c *Client) flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	for c.conn.isConnected() {
+		e, ok := c.outbox.peek()
+		if !ok {
+			return nil
+		}
+
+		if !e.Expires.IsZero() && time.Now().After(e.Expires) {
+			log.Printf("mqtt: dropping expired message on %s", e.Topic)
+			c.outbox.remove(e.Seq)
+			continue
+		}
+
+		if err := c.send(ctx, e); err != nil {
+			c.recordFailure(e.Topic, err)
+			if ctx.Err() != nil {
+				return err
+			}
+			if errors.Is(err, errRejected) || c.outbox.attempt(e.Seq) >= maxPublishAttempts {
+				log.Printf("mqtt: giving up on message to %s: %v", e.Topic, err)
+				c.outbox.drop(e.Seq)
+				continue
+			}
+			return err
+		}
+
+		c.outbox.remove(e.Seq)
+		c.mu.Lock()
+		c.stats.Sent++
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, e outboxEntry) error {
+	if !validTopicName(e.Topic) {
+		return fmt.Errorf("publish to %q: %w: invalid topic", e.Topic, errRejected)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.publishTimeout)
+	defer cancel()
+
+	return c.conn.publish(ctx, e)
+}
+
+func (c *Client) recordFailure(topic string, err error) {
+	c.mu.Lock()
+	c.stats.Failed++
+	c.stats.LastError = err.Error()
+	c.mu.Unlock()
+
+	log.Printf("mqtt: publish to %s failed: %v", topic, err)
+	if c.onPublishError != nil {
+		c.onPublishError(topic, err)
+	}
+}
+
+func validTopicName(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#\x00")
+}
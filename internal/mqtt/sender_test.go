@@ -0,0 +1,150 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type hangingToken struct{ mockToken }
+
+func (t *hangingToken) Done() <-chan struct{} { return nil }
+
+func TestSendTimesOutOnHalfOpenConnection(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	client.publishTimeout = 20 * time.Millisecond
+	_ = client.Connect()
+
+	var failedTopic string
+	var failedErr error
+	failed := make(chan struct{}, 1)
+	client.SetOnPublishError(func(topic string, err error) {
+		failedTopic, failedErr = topic, err
+		failed <- struct{}{}
+	})
+
+	mock.mu.Lock()
+	mock.hang = true
+	mock.mu.Unlock()
+
+	start := time.Now()
+	if err := client.PublishStatus("online"); err != nil {
+		t.Fatalf("PublishStatus() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("PublishStatus() blocked for %s", elapsed)
+	}
+
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("expected publish error callback after timeout")
+	}
+	if failedTopic != "stat/test-pc/status" || !errors.Is(failedErr, context.DeadlineExceeded) {
+		t.Errorf("callback = (%q, %v), want status topic and deadline exceeded", failedTopic, failedErr)
+	}
+
+	stats := client.Stats()
+	if stats.Queued != 1 || stats.Failed != 1 || stats.Sent != 0 {
+		t.Errorf("stats = %+v, want 1 queued and 1 failed", stats)
+	}
+}
+
+func TestFlushStopsOnContextCancel(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+	client.cancel()
+
+	mock.hang = true
+	client.outbox.push("stat/test-pc/status", []byte("online"), true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.flush(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("flush() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestStatsCountsSentMessages(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	defer client.Disconnect()
+	_ = client.Connect()
+
+	_ = client.PublishVersion("v1.2.3")
+	_ = client.PublishStatus("online")
+	waitPublished(t, mock, 2)
+
+	deadline := time.Now().Add(time.Second)
+	for client.Stats().Sent < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := client.Stats(); stats.Sent != 2 || stats.Queued != 0 {
+		t.Errorf("stats = %+v, want 2 sent and nothing queued", stats)
+	}
+}
+
+func TestFlushDropsExpiredMessages(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+	client.cancel()
+
+	client.outbox.enqueue(outboxEntry{Topic: "stat/test-pc/result", Payload: []byte("late"), Expires: time.Now().Add(-time.Second)})
+	client.outbox.push("stat/test-pc/status", []byte("online"), true)
+
+	if err := client.flush(context.Background()); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	msgs := mock.messages()
+	if len(msgs) != 1 || msgs[0].topic != "stat/test-pc/status" {
+		t.Errorf("published = %v, want only the status", msgs)
+	}
+}
+
+func TestFlushDropsInvalidTopic(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+	client.cancel()
+
+	client.outbox.push("stat/test-pc/result/#", []byte("bad"), false)
+	client.outbox.push("stat/test-pc/status", []byte("online"), true)
+
+	if err := client.flush(context.Background()); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	msgs := mock.messages()
+	if len(msgs) != 1 || msgs[0].topic != "stat/test-pc/status" {
+		t.Errorf("published = %v, want only the status", msgs)
+	}
+	if stats := client.Stats(); stats.Queued != 0 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want nothing queued and 1 dropped", stats)
+	}
+}
+
+func TestFlushGivesUpAfterMaxAttempts(t *testing.T) {
+	client, mock := newTestClient(testConfig())
+	_ = client.Connect()
+	client.cancel()
+	client.publishTimeout = time.Millisecond
+
+	client.outbox.push("stat/test-pc/status", []byte("online"), true)
+	client.outbox.push("stat/test-pc/mode", []byte("ACTIVE"), true)
+
+	mock.mu.Lock()
+	mock.hang = true
+	mock.mu.Unlock()
+	for range maxPublishAttempts {
+		if err := client.flush(context.Background()); err == nil {
+			t.Fatal("flush() expected error while the broker does not answer")
+		}
+	}
+
+	head, ok := client.outbox.peek()
+	if !ok || head.Topic != "stat/test-pc/mode" || head.Attempts != 1 {
+		t.Errorf("outbox head = %+v, want the mode entry after one attempt", head)
+	}
+	if stats := client.Stats(); stats.Queued != 1 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want 1 queued and 1 dropped", stats)
+	}
+}
@@ -0,0 +1,148 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cret"
+
+func signedEnvelope(t *testing.T, topic, payload string, at time.Time) *Envelope {
+	t.Helper()
+	data, err := SignCommand(testSecret, topic, Envelope{}, []byte(payload), at)
+	if err != nil {
+		t.Fatalf("SignCommand() error = %v", err)
+	}
+	_, env := parseCommand("mode", data)
+	if env == nil {
+		t.Fatalf("signed payload %s not parsed as envelope", data)
+	}
+	return env
+}
+
+func TestVerifierAcceptsSignedCommand(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	v := newVerifier(testSecret, time.Minute)
+	v.now = func() time.Time { return now }
+
+	env := signedEnvelope(t, "cmnd/test-pc/mode", "ACTIVE", now)
+	if err := v.verify("cmnd/test-pc/mode", env); err != nil {
+		t.Fatalf("verify() error = %v", err)
+	}
+	if string(env.Payload) != `"ACTIVE"` {
+		t.Errorf("Payload = %s, want %q encoded as JSON", env.Payload, "ACTIVE")
+	}
+}
+
+func TestVerifierAcceptsNonCanonicalPayload(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	v := newVerifier(testSecret, time.Minute)
+	v.now = func() time.Time { return now }
+
+	for _, payload := range []string{
+		`["a", "b"]`,
+		`{ "title": "x", "message": "A & B <c>" }`,
+	} {
+		env := signedEnvelope(t, "cmnd/test-pc/notify", payload, now)
+		if err := v.verify("cmnd/test-pc/notify", env); err != nil {
+			t.Errorf("verify(%s) error = %v", payload, err)
+		}
+	}
+}
+
+func TestVerifierRejects(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	topic := "cmnd/test-pc/mode"
+
+	tampered := signedEnvelope(t, topic, "BLOCKED", now)
+	tampered.Payload = json.RawMessage(`"ACTIVE"`)
+
+	cases := []struct {
+		name  string
+		topic string
+		env   *Envelope
+		want  error
+	}{
+		{"unsigned", topic, nil, ErrUnsigned},
+		{"wrong topic", "cmnd/test-pc/notify", signedEnvelope(t, topic, "ACTIVE", now), ErrInvalidSignature},
+		{"tampered payload", topic, tampered, ErrInvalidSignature},
+		{"stale", topic, signedEnvelope(t, topic, "ACTIVE", now.Add(-2*time.Minute)), ErrStaleCommand},
+		{"future", topic, signedEnvelope(t, topic, "ACTIVE", now.Add(2*time.Minute)), ErrStaleCommand},
+	}
+
+	for _, tc := range cases {
+		v := newVerifier(testSecret, time.Minute)
+		v.now = func() time.Time { return now }
+		if err := v.verify(tc.topic, tc.env); !errors.Is(err, tc.want) {
+			t.Errorf("%s: verify() error = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestVerifierRejectsReplay(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	v := newVerifier(testSecret, time.Minute)
+	v.now = func() time.Time { return now }
+
+	env := signedEnvelope(t, "cmnd/test-pc/mode", "ACTIVE", now)
+	if err := v.verify("cmnd/test-pc/mode", env); err != nil {
+		t.Fatalf("first verify() error = %v", err)
+	}
+	if err := v.verify("cmnd/test-pc/mode", env); !errors.Is(err, ErrReplayedCommand) {
+		t.Errorf("second verify() error = %v, want %v", err, ErrReplayedCommand)
+	}
+}
+
+func TestSubscribeCommandRejectsUnsigned(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.Secret = testSecret
+	client, mock := newTestClient(cfg)
+	_ = client.Connect()
+
+	called := false
+	_ = client.SubscribeCommand("mode", func(cmd Command) Result {
+		called = true
+		return Ack("mode updated", nil)
+	})
+
+	mock.subscriptions["cmnd/test-pc/mode"](mock, &mockMessage{topic: "cmnd/test-pc/mode", payload: []byte("ACTIVE")})
+
+	if called {
+		t.Error("expected unsigned command to be rejected")
+	}
+	published := waitPublished(t, mock, 2)
+	if len(published) != 2 {
+		t.Fatalf("expected tamper event and result, got %v", published)
+	}
+	if published[0].topic != "stat/test-pc/tamper" {
+		t.Errorf("topic = %q, want %q", published[0].topic, "stat/test-pc/tamper")
+	}
+	if published[1].topic != "stat/test-pc/result" {
+		t.Errorf("topic = %q, want %q", published[1].topic, "stat/test-pc/result")
+	}
+}
+
+func TestSubscribeCommandAcceptsSigned(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.Secret = testSecret
+	client, mock := newTestClient(cfg)
+	_ = client.Connect()
+
+	var received string
+	_ = client.SubscribeCommand("blacklist/set", func(cmd Command) Result {
+		received = string(cmd.Payload)
+		return Ack("blacklist updated", nil)
+	})
+
+	payload, err := SignCommand(testSecret, "cmnd/test-pc/blacklist/set", Envelope{ID: "7"}, []byte(`["game.exe"]`), time.Now())
+	if err != nil {
+		t.Fatalf("SignCommand() error = %v", err)
+	}
+	mock.subscriptions["cmnd/test-pc/blacklist/set"](mock, &mockMessage{topic: "cmnd/test-pc/blacklist/set", payload: payload})
+
+	if received != `["game.exe"]` {
+		t.Errorf("received = %q, want %q", received, `["game.exe"]`)
+	}
+}
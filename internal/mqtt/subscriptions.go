@@ -0,0 +1,93 @@
+package mqtt
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+type subscription struct {
+	topic   string
+	handler func(inboundMessage)
+}
+
+func (c *Client) Subscribe(topic string, handler func(payload []byte)) error {
+	return c.subscribeMessage(topic, func(msg inboundMessage) {
+		handler(msg.Payload)
+	})
+}
+
+func (c *Client) subscribeMessage(topic string, handler func(inboundMessage)) error {
+	h := c.messageHandler(handler)
+
+	c.mu.Lock()
+	replaced := false
+	for i := range c.subs {
+		if c.subs[i].topic == topic {
+			c.subs[i].handler = h
+			replaced = true
+		}
+	}
+	if !replaced {
+		c.subs = append(c.subs, subscription{topic: topic, handler: h})
+	}
+	c.mu.Unlock()
+
+	if c.conn == nil || !c.conn.isConnected() {
+		return nil
+	}
+	return c.subscribe(topic, h)
+}
+
+func (c *Client) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	for i := range c.subs {
+		if c.subs[i].topic == topic {
+			c.subs = append(c.subs[:i], c.subs[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if c.conn == nil || !c.conn.isConnected() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.publishTimeout)
+	defer cancel()
+	return c.conn.unsubscribe(ctx, topic)
+}
+
+func (c *Client) resubscribe() {
+	c.mu.Lock()
+	subs := append([]subscription(nil), c.subs...)
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		if err := c.subscribe(s.topic, s.handler); err != nil {
+			log.Printf("failed to subscribe to %s: %v", s.topic, err)
+		}
+	}
+}
+
+func (c *Client) subscribe(topic string, handler func(inboundMessage)) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.publishTimeout)
+	defer cancel()
+	return c.conn.subscribe(ctx, topic, handler)
+}
+
+func (c *Client) messageHandler(handler func(inboundMessage)) func(inboundMessage) {
+	return func(msg inboundMessage) {
+		if cipher := c.currentCipher(); cipher.applies(msg.Topic) {
+			plaintext, err := cipher.open(msg.Topic, msg.Payload)
+			if err != nil {
+				log.Printf("mqtt: dropping message on %s: %v", msg.Topic, err)
+				if strings.HasPrefix(msg.Topic, c.Topics().Cmnd("")) {
+					c.reportTamper(msg.Topic, err)
+				}
+				return
+			}
+			msg.Payload = plaintext
+		}
+		handler(msg)
+	}
+}
@@ -0,0 +1,71 @@
+package mqtt
+
+import (
+	"strings"
+
+	"home-guard/internal/config"
+)
+
+const (
+	defaultStatSegment     = "stat"
+	defaultCmndSegment     = "cmnd"
+	defaultDiscoveryPrefix = "homeassistant"
+)
+
+type Topics struct {
+	id        string
+	prefix    string
+	stat      string
+	cmnd      string
+	discovery string
+}
+
+func NewTopics(cfg config.TopicConfig, clientID string) Topics {
+	return Topics{
+		id:        clientID,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		stat:      segmentOrDefault(cfg.Stat, defaultStatSegment),
+		cmnd:      segmentOrDefault(cfg.Cmnd, defaultCmndSegment),
+		discovery: segmentOrDefault(cfg.DiscoveryPrefix, defaultDiscoveryPrefix),
+	}
+}
+
+func segmentOrDefault(value, fallback string) string {
+	if v := strings.Trim(value, "/"); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (t Topics) Stat(name string) string {
+	return t.join(t.stat, t.id, name)
+}
+
+func (t Topics) Cmnd(name string) string {
+	return t.join(t.cmnd, t.id, name)
+}
+
+func (t Topics) DiscoveryPrefix() string {
+	return t.discovery
+}
+
+func (t Topics) Discovery(component, object string) string {
+	return strings.Join([]string{t.discovery, component, t.id, object, "config"}, "/")
+}
+
+func (t Topics) join(parts ...string) string {
+	if t.prefix != "" {
+		parts = append([]string{t.prefix}, parts...)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (t Topics) WithDiscoveryPrefix(prefix string) Topics {
+	t.discovery = segmentOrDefault(prefix, defaultDiscoveryPrefix)
+	return t
+}
+
+func (t Topics) WithClientID(id string) Topics {
+	t.id = id
+	return t
+}
@@ -0,0 +1,47 @@
+package mqtt
+
+import (
+	"testing"
+
+	"home-guard/internal/config"
+)
+
+func TestTopicsDefaults(t *testing.T) {
+	topics := NewTopics(config.TopicConfig{}, "test-pc")
+
+	cases := []struct {
+		got, want string
+	}{
+		{topics.Stat("status"), "stat/test-pc/status"},
+		{topics.Cmnd("blacklist/set"), "cmnd/test-pc/blacklist/set"},
+		{topics.Discovery("select", "mode"), "homeassistant/select/test-pc/mode/config"},
+		{topics.DiscoveryPrefix(), "homeassistant"},
+	}
+	for _, tc := range cases {
+		if tc.got != tc.want {
+			t.Errorf("topic = %q, want %q", tc.got, tc.want)
+		}
+	}
+}
+
+func TestTopicsCustomLayout(t *testing.T) {
+	topics := NewTopics(config.TopicConfig{
+		Prefix:          "maison/",
+		Stat:            "tele",
+		Cmnd:            "/cmd/",
+		DiscoveryPrefix: "ha",
+	}, "test-pc")
+
+	cases := []struct {
+		got, want string
+	}{
+		{topics.Stat("current_mode"), "maison/tele/test-pc/current_mode"},
+		{topics.Cmnd("mode"), "maison/cmd/test-pc/mode"},
+		{topics.Discovery("sensor", "version"), "ha/sensor/test-pc/version/config"},
+	}
+	for _, tc := range cases {
+		if tc.got != tc.want {
+			t.Errorf("topic = %q, want %q", tc.got, tc.want)
+		}
+	}
+}
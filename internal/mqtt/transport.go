@@ -0,0 +1,65 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+
+	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type inboundMessage struct {
+	Topic          string
+	Payload        []byte
+	ResponseTopic  string
+	Correlation    []byte
+	UserProperties map[string]string
+}
+
+type transport interface {
+	connect(ctx context.Context) error
+	isConnected() bool
+	publish(ctx context.Context, e outboxEntry) error
+	subscribe(ctx context.Context, topic string, handler func(inboundMessage)) error
+	unsubscribe(ctx context.Context, topic string) error
+	disconnect()
+}
+
+type pahoTransport struct {
+	client pahomqtt.Client
+}
+
+func (t *pahoTransport) connect(ctx context.Context) error {
+	return waitToken(ctx, t.client.Connect(), "connect")
+}
+
+func (t *pahoTransport) isConnected() bool {
+	return t.client.IsConnected()
+}
+
+func (t *pahoTransport) publish(ctx context.Context, e outboxEntry) error {
+	return waitToken(ctx, t.client.Publish(e.Topic, 1, e.Retained, e.Payload), "publish to "+e.Topic)
+}
+
+func (t *pahoTransport) subscribe(ctx context.Context, topic string, handler func(inboundMessage)) error {
+	token := t.client.Subscribe(topic, 1, func(_ pahomqtt.Client, msg pahomqtt.Message) {
+		handler(inboundMessage{Topic: msg.Topic(), Payload: msg.Payload()})
+	})
+	return waitToken(ctx, token, "subscribe to "+topic)
+}
+
+func (t *pahoTransport) unsubscribe(ctx context.Context, topic string) error {
+	return waitToken(ctx, t.client.Unsubscribe(topic), "unsubscribe from "+topic)
+}
+
+func (t *pahoTransport) disconnect() {
+	t.client.Disconnect(250)
+}
+
+func waitToken(ctx context.Context, token pahomqtt.Token, op string) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+}
@@ -0,0 +1,259 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const defaultSessionExpiry = 24 * time.Hour
+
+type pahoV5Transport struct {
+	cfg autopaho.ClientConfig
+
+	mu       sync.Mutex
+	cm       *autopaho.ConnectionManager
+	up       bool
+	handlers map[string]func(inboundMessage)
+}
+
+func (c *Client) newV5Transport() (*pahoV5Transport, error) {
+	t := &pahoV5Transport{handlers: make(map[string]func(inboundMessage))}
+
+	var servers []*url.URL
+	for _, s := range c.servers {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, u)
+	}
+
+	sessionExpiry := defaultSessionExpiry
+	if c.cfg.MQTT.SessionExpiry > 0 {
+		sessionExpiry = time.Duration(c.cfg.MQTT.SessionExpiry) * time.Second
+	}
+	if c.passive {
+		sessionExpiry = 0
+	}
+
+	t.cfg = autopaho.ClientConfig{
+		ServerUrls:                    servers,
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: c.passive,
+		SessionExpiryInterval:         uint32(sessionExpiry / time.Second),
+		ConnectTimeout:                connectTimeout,
+		ReconnectBackoff: func(attempt int) time.Duration {
+			if attempt == 0 {
+				return 0
+			}
+			return exponentialDelay(attempt)
+		},
+		ConnectUsername: c.cfg.Username,
+		ConnectPassword: []byte(c.cfg.Password),
+		ConnectPacketBuilder: func(cp *paho.Connect, u *url.URL) (*paho.Connect, error) {
+			c.mu.Lock()
+			c.attempting = u.String()
+			c.mu.Unlock()
+			return cp, nil
+		},
+		OnConnectionUp: func(_ *autopaho.ConnectionManager, connack *paho.Connack) {
+			t.setUp(true)
+			if connack.SessionPresent {
+				log.Printf("MQTT v5 session resumed (reason code 0x%02x)", connack.ReasonCode)
+			}
+			go c.handleConnect()
+		},
+		OnConnectionDown: func() bool {
+			t.setUp(false)
+			log.Printf("MQTT v5 connection lost")
+			return true
+		},
+		OnConnectError: func(err error) {
+			var connackErr *autopaho.ConnackError
+			if errors.As(err, &connackErr) {
+				log.Printf("MQTT v5 connection refused: reason code 0x%02x %s", connackErr.ReasonCode, connackErr.Reason)
+				return
+			}
+			log.Printf("MQTT v5 connect error: %v", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: c.clientID(),
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					return t.route(pr.Packet), nil
+				},
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				reason := ""
+				if d.Properties != nil {
+					reason = d.Properties.ReasonString
+				}
+				log.Printf("MQTT v5 server disconnected us: reason code 0x%02x %s", d.ReasonCode, reason)
+			},
+			OnClientError: func(err error) {
+				log.Printf("MQTT v5 client error: %v", err)
+			},
+		},
+	}
+	if !c.passive {
+		t.cfg.WillMessage = &paho.WillMessage{
+			Topic:   c.Topics().Stat("status"),
+			Payload: []byte("offline"),
+			QoS:     1,
+			Retain:  true,
+		}
+	}
+	return t, nil
+}
+
+func (t *pahoV5Transport) setUp(up bool) {
+	t.mu.Lock()
+	t.up = up
+	t.mu.Unlock()
+}
+
+func (t *pahoV5Transport) manager() *autopaho.ConnectionManager {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cm
+}
+
+func (t *pahoV5Transport) connect(ctx context.Context) error {
+	t.mu.Lock()
+	if t.cm == nil {
+		cm, err := autopaho.NewConnection(context.Background(), t.cfg)
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		t.cm = cm
+	}
+	cm := t.cm
+	t.mu.Unlock()
+
+	return cm.AwaitConnection(ctx)
+}
+
+func (t *pahoV5Transport) isConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cm != nil && t.up
+}
+
+func (t *pahoV5Transport) publish(ctx context.Context, e outboxEntry) error {
+	cm := t.manager()
+	if cm == nil {
+		return autopaho.ConnectionDownError
+	}
+
+	props := &paho.PublishProperties{CorrelationData: e.Correlation}
+	if !e.Expires.IsZero() {
+		expiry := uint32(max(time.Until(e.Expires)/time.Second, 1))
+		props.MessageExpiry = &expiry
+	}
+	for k, v := range e.Properties {
+		props.User.Add(k, v)
+	}
+
+	resp, err := cm.Publish(ctx, &paho.Publish{
+		QoS:        1,
+		Retain:     e.Retained,
+		Topic:      e.Topic,
+		Payload:    e.Payload,
+		Properties: props,
+	})
+	if err != nil && resp != nil && resp.ReasonCode >= 0x80 {
+		return fmt.Errorf("publish to %s: %w: %w (reason code 0x%02x)", e.Topic, errRejected, err, resp.ReasonCode)
+	}
+	if err != nil && resp != nil {
+		return fmt.Errorf("publish to %s: %w (reason code 0x%02x)", e.Topic, err, resp.ReasonCode)
+	}
+	return err
+}
+
+func (t *pahoV5Transport) subscribe(ctx context.Context, topic string, handler func(inboundMessage)) error {
+	t.mu.Lock()
+	t.handlers[topic] = handler
+	cm := t.cm
+	t.mu.Unlock()
+	if cm == nil {
+		return autopaho.ConnectionDownError
+	}
+
+	suback, err := cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: 1}},
+	})
+	if err != nil && suback != nil && len(suback.Reasons) > 0 {
+		return fmt.Errorf("subscribe to %s: %w (reason code 0x%02x)", topic, err, suback.Reasons[0])
+	}
+	return err
+}
+
+func (t *pahoV5Transport) unsubscribe(ctx context.Context, topic string) error {
+	t.mu.Lock()
+	delete(t.handlers, topic)
+	cm := t.cm
+	t.mu.Unlock()
+	if cm == nil {
+		return autopaho.ConnectionDownError
+	}
+
+	unsuback, err := cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{topic}})
+	if err != nil && unsuback != nil && len(unsuback.Reasons) > 0 {
+		return fmt.Errorf("unsubscribe from %s: %w (reason code 0x%02x)", topic, err, unsuback.Reasons[0])
+	}
+	return err
+}
+
+func (t *pahoV5Transport) disconnect() {
+	t.mu.Lock()
+	cm := t.cm
+	t.cm = nil
+	t.up = false
+	t.mu.Unlock()
+	if cm == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cm.Disconnect(ctx); err != nil {
+		log.Printf("MQTT v5 disconnect: %v", err)
+	}
+}
+
+func (t *pahoV5Transport) route(p *paho.Publish) bool {
+	msg := inboundMessage{Topic: p.Topic, Payload: p.Payload}
+	if p.Properties != nil {
+		msg.ResponseTopic = p.Properties.ResponseTopic
+		msg.Correlation = p.Properties.CorrelationData
+		if len(p.Properties.User) > 0 {
+			msg.UserProperties = make(map[string]string, len(p.Properties.User))
+			for _, u := range p.Properties.User {
+				msg.UserProperties[u.Key] = u.Value
+			}
+		}
+	}
+
+	t.mu.Lock()
+	var matched []func(inboundMessage)
+	for filter, h := range t.handlers {
+		if topicMatches(filter, p.Topic) {
+			matched = append(matched, h)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, h := range matched {
+		h(msg)
+	}
+	return len(matched) > 0
+}
@@ -0,0 +1,63 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+func TestConnectRejectsUnknownProtocol(t *testing.T) {
+	cfg := testConfig()
+	cfg.MQTT.Protocol = 4
+	client, _ := newTestClient(cfg)
+
+	if err := client.Connect(); err == nil {
+		t.Fatal("Connect() error = nil, want unsupported protocol")
+	}
+}
+
+func TestNewV5TransportConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.MQTT.Protocol = 5
+	cfg.MQTT.SessionExpiry = 600
+	client := NewClient(cfg)
+	client.servers = []string{"tcp://localhost:1883", "wss://backup.lan:443"}
+
+	tr, err := client.newV5Transport()
+	if err != nil {
+		t.Fatalf("newV5Transport() error = %v", err)
+	}
+	if len(tr.cfg.ServerUrls) != 2 || tr.cfg.ServerUrls[1].Scheme != "wss" {
+		t.Errorf("ServerUrls = %v, want both brokers", tr.cfg.ServerUrls)
+	}
+	if tr.cfg.SessionExpiryInterval != 600 {
+		t.Errorf("SessionExpiryInterval = %d, want 600", tr.cfg.SessionExpiryInterval)
+	}
+	if w := tr.cfg.WillMessage; w == nil || w.Topic != "stat/test-pc/status" || string(w.Payload) != "offline" || !w.Retain {
+		t.Errorf("WillMessage = %+v, want retained offline status", w)
+	}
+}
+
+func TestV5TransportRoutesMessages(t *testing.T) {
+	tr := &pahoV5Transport{handlers: make(map[string]func(inboundMessage))}
+
+	var got inboundMessage
+	tr.handlers["cmnd/test-pc/+"] = func(msg inboundMessage) { got = msg }
+
+	props := &paho.PublishProperties{ResponseTopic: "ha/replies", CorrelationData: []byte("c1")}
+	props.User.Add("id", "9")
+	handled := tr.route(&paho.Publish{Topic: "cmnd/test-pc/mode", Payload: []byte("BLOCKED"), Properties: props})
+
+	if !handled {
+		t.Fatal("route() = false, want message handled")
+	}
+	if got.Topic != "cmnd/test-pc/mode" || string(got.Payload) != "BLOCKED" {
+		t.Errorf("message = %+v, want mode command", got)
+	}
+	if got.ResponseTopic != "ha/replies" || string(got.Correlation) != "c1" || got.UserProperties["id"] != "9" {
+		t.Errorf("message = %+v, want v5 properties", got)
+	}
+	if tr.route(&paho.Publish{Topic: "stat/test-pc/mode"}) {
+		t.Error("route() = true for unsubscribed topic")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package session
+
+import "errors"
+
+func UserActive() (bool, error) {
+	return false, errors.New("not supported on this platform")
+}
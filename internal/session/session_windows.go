@@ -0,0 +1,83 @@
+//go:build windows
+
+package session
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modWtsapi32                    = windows.NewLazySystemDLL("wtsapi32.dll")
+	procWTSEnumerateSessionsW      = modWtsapi32.NewProc("WTSEnumerateSessionsW")
+	procWTSQuerySessionInformation = modWtsapi32.NewProc("WTSQuerySessionInformationW")
+	procWTSFreeMemory              = modWtsapi32.NewProc("WTSFreeMemory")
+)
+
+type wtsSessionInfo struct {
+	SessionID      uint32
+	WinStationName *uint16
+	State          uint32
+}
+
+type wtsInfoEx struct {
+	Level        uint32
+	_            uint32
+	SessionID    uint32
+	SessionState uint32
+	SessionFlags int32
+}
+
+const (
+	wtsActive          = 0
+	wtsSessionInfoEx   = 25
+	wtsSessionUnlocked = 1
+)
+
+func UserActive() (bool, error) {
+	var pSessions *wtsSessionInfo
+	var count uint32
+
+	ret, _, err := procWTSEnumerateSessionsW.Call(
+		0, 0, 1,
+		uintptr(unsafe.Pointer(&pSessions)),
+		uintptr(unsafe.Pointer(&count)),
+	)
+	if ret == 0 {
+		return false, fmt.Errorf("WTSEnumerateSessions: %w", err)
+	}
+	defer procWTSFreeMemory.Call(uintptr(unsafe.Pointer(pSessions)))
+
+	for _, s := range unsafe.Slice(pSessions, count) {
+		if s.State != wtsActive {
+			continue
+		}
+		unlocked, err := sessionUnlocked(s.SessionID)
+		if err != nil {
+			return false, err
+		}
+		if unlocked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func sessionUnlocked(id uint32) (bool, error) {
+	var info *wtsInfoEx
+	var size uint32
+
+	ret, _, err := procWTSQuerySessionInformation.Call(
+		0, uintptr(id), wtsSessionInfoEx,
+		uintptr(unsafe.Pointer(&info)),
+		uintptr(unsafe.Pointer(&size)),
+	)
+	if ret == 0 {
+		return false, fmt.Errorf("WTSQuerySessionInformation: %w", err)
+	}
+	defer procWTSFreeMemory.Call(uintptr(unsafe.Pointer(info)))
+
+	return info.Level == 1 && info.SessionFlags == wtsSessionUnlocked, nil
+}
@@ -0,0 +1,111 @@
+package update
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	StatusFile = "update.json"
+
+	maxSummaryLength = 255
+)
+
+type Status struct {
+	InstalledVersion string    `json:"installed_version"`
+	LatestVersion    string    `json:"latest_version"`
+	Title            string    `json:"title,omitempty"`
+	ReleaseSummary   string    `json:"release_summary,omitempty"`
+	ReleaseURL       string    `json:"release_url,omitempty"`
+	InProgress       bool      `json:"in_progress"`
+	Progress         int       `json:"update_percentage,omitempty"`
+	Result           string    `json:"result,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	CheckedAt        time.Time `json:"checked_at,omitzero"`
+	Deferred         string    `json:"deferred,omitempty"`
+	DeferredSince    time.Time `json:"deferred_since,omitzero"`
+}
+
+func LoadStatus(dir string) (Status, error) {
+	var s Status
+	data, err := os.ReadFile(filepath.Join(dir, StatusFile))
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+func SaveStatus(dir string, s Status) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, StatusFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func Summary(notes string) string {
+	r := []rune(notes)
+	if len(r) <= maxSummaryLength {
+		return notes
+	}
+	return string(r[:maxSummaryLength-1]) + "…"
+}
+
+const AgentStateFile = "agent_state.json"
+
+type AgentState struct {
+	Mode               string `json:"mode"`
+	RunningBlacklisted bool   `json:"running_blacklisted"`
+	UserActive         bool   `json:"user_active"`
+}
+
+func LoadAgentState(dir string) (AgentState, error) {
+	var s AgentState
+	data, err := os.ReadFile(filepath.Join(dir, AgentStateFile))
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+func SaveAgentState(dir string, s AgentState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, AgentStateFile), data, 0644)
+}
+
+const HealthFile = "agent_health.json"
+
+type Health struct {
+	Version string    `json:"version"`
+	At      time.Time `json:"at"`
+}
+
+func LoadHealth(dir string) (Health, error) {
+	var h Health
+	data, err := os.ReadFile(filepath.Join(dir, HealthFile))
+	if err != nil {
+		return h, err
+	}
+	err = json.Unmarshal(data, &h)
+	return h, err
+}
+
+func SaveHealth(dir string, h Health) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, HealthFile), data, 0644)
+}
@@ -0,0 +1,68 @@
+package update
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := Status{
+		InstalledVersion: "v1.2.0",
+		LatestVersion:    "v1.3.0",
+		ReleaseURL:       "https://example.org/v1.3.0",
+		InProgress:       true,
+		CheckedAt:        time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := SaveStatus(dir, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("LoadStatus() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadStatusMissing(t *testing.T) {
+	if _, err := LoadStatus(t.TempDir()); err == nil {
+		t.Error("expected error for missing status file")
+	}
+}
+
+func TestSummaryTruncates(t *testing.T) {
+	if got := Summary("court"); got != "court" {
+		t.Errorf("Summary() = %q, want %q", got, "court")
+	}
+	got := Summary(strings.Repeat("é", 300))
+	if n := len([]rune(got)); n != maxSummaryLength {
+		t.Errorf("len(Summary()) = %d runes, want %d", n, maxSummaryLength)
+	}
+}
+
+func TestAgentStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := AgentState{Mode: "BLOCKED", RunningBlacklisted: true, UserActive: true}
+	if err := SaveAgentState(dir, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadAgentState(dir)
+	if err != nil || got != want {
+		t.Errorf("LoadAgentState() = %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestHealthRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := Health{Version: "v1.2.0", At: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := SaveHealth(dir, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadHealth(dir)
+	if err != nil || got.Version != want.Version || !got.At.Equal(want.At) {
+		t.Errorf("LoadHealth() = %+v, %v, want %+v", got, err, want)
+	}
+}